@@ -0,0 +1,101 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// aestheticBits holds how many bits of luck each character of the different aesthetic openings is worth,
+// i.e. -log2 of the chance of a random character continuing the opening. It depends on the event ID alphabet,
+// so it's only computed once the flags have been parsed.
+var aestheticBits = sync.OnceValue(func() (bits struct{ digit, firstLetter, nextLetter float64 }) {
+	var digits, letters float64
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		if char := eventIDAlphabet[i]; '0' <= char && char <= '9' {
+			digits++
+		} else if isASCIILetter(char) {
+			letters++
+		}
+	}
+	size := float64(len(eventIDAlphabet))
+	bits.digit = math.Log2(size / max(digits, 1))
+	bits.firstLetter = math.Log2(size / max(letters, 1))
+	bits.nextLetter = math.Log2(size / max(letters/2, 1))
+	return
+})
+
+// aestheticScore rates how visually striking the start of an event ID is, by how unlikely it is to happen by chance.
+// The score is the number of bits of luck the best of these openings took:
+//
+//   - the same character repeated, like aaaaa
+//   - only digits, like 31415
+//   - letters alternating between upper and lower case, like HeLlO
+func aestheticScore(eventID []byte) int {
+	if len(eventID) == 0 {
+		return 0
+	}
+	bits := aestheticBits()
+	run := 1
+	for run < len(eventID) && eventID[run] == eventID[0] {
+		run++
+	}
+	digits := 0
+	for digits < len(eventID) && '0' <= eventID[digits] && eventID[digits] <= '9' {
+		digits++
+	}
+	alternating := 0
+	for alternating < len(eventID) && isASCIILetter(eventID[alternating]) &&
+		(alternating == 0 || (eventID[alternating]^eventID[alternating-1])&0x20 != 0) {
+		alternating++
+	}
+	score := max(
+		float64(run-1)*math.Log2(float64(len(eventIDAlphabet))),
+		float64(digits)*bits.digit,
+		0,
+	)
+	if alternating > 1 {
+		score = max(score, bits.firstLetter+float64(alternating-1)*bits.nextLetter)
+	}
+	return int(score)
+}
+
+// scorers are the ways near-miss candidates can be scored instead of by how much of the target prefix they match.
+var scorers = map[string]func(eventID []byte) int{
+	"pronounceable": func(eventID []byte) int { return pronounceableLength(eventID, len(eventID)) },
+	"aesthetic":     aestheticScore,
+}
+
+// activeScorer returns the name of the scorer selected with --score or --pronounceable, or an empty string.
+func activeScorer() string {
+	if *pronounceable > 0 {
+		return "pronounceable"
+	}
+	return *scoreMode
+}
+
+// describeScore returns a human-readable description of the score of a near-miss candidate.
+func describeScore(c *Candidate) string {
+	switch activeScorer() {
+	case "pronounceable":
+		return fmt.Sprintf("starts with %d pronounceable characters", c.MatchedLength)
+	case "aesthetic":
+		return fmt.Sprintf("has an aesthetic score of %d", c.MatchedLength)
+	default:
+		return fmt.Sprintf("matched %d/%d characters of the prefix", c.MatchedLength, len(firstTargetPrefix()))
+	}
+}
+
+// scorerNames returns the valid values for --score, for error messages.
+func scorerNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(scorers)), ", ")
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Multi-prefix modes switch from AnyPrefixMatcher to the automaton when there are at least this many literal prefixes.
+// Below this, checking each prefix with its own fast compare is just as fast.
+const acMinPatterns = 16
+
+type acNode struct {
+	children [64]int32
+	// Whether a pattern ends at this node. In anywhere mode, this also includes patterns ending at any suffix of the node.
+	terminal bool
+}
+
+// AhoCorasickMatcher accepts event IDs that contain any of a set of literal patterns, either as a prefix or anywhere.
+// The patterns are stored in a trie indexed by alphabet position, so matching takes one array lookup per character
+// regardless of how many patterns there are. For matching anywhere, the trie is turned into an Aho–Corasick
+// automaton with the failure links folded into the child arrays, so it never needs to backtrack.
+type AhoCorasickMatcher struct {
+	// Maps bytes to their index in the alphabet (or the index of the other case with ignoreCase), -1 if not in the alphabet.
+	index [256]int8
+	// How many alphabet characters map to each index.
+	weight   [64]int
+	nodes    []acNode
+	patterns int
+	anywhere bool
+	prob     float64
+}
+
+var _ MaskedPrefixer = (*AhoCorasickMatcher)(nil)
+
+// NewAhoCorasickMatcher builds a matcher for the given patterns. Patterns containing characters
+// that can't appear in event IDs are skipped. If anywhere is false, patterns must match at the start.
+func NewAhoCorasickMatcher(patterns []string, ignoreCase, anywhere bool) *AhoCorasickMatcher {
+	acm := &AhoCorasickMatcher{nodes: make([]acNode, 1), anywhere: anywhere}
+	for i := range acm.index {
+		acm.index[i] = -1
+	}
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		acm.index[eventIDAlphabet[i]] = int8(i)
+	}
+	if ignoreCase {
+		for i := 0; i < len(eventIDAlphabet); i++ {
+			char := eventIDAlphabet[i]
+			if upper := acm.index[char&caseFoldMask]; 'a' <= char && char <= 'z' && upper != -1 {
+				acm.index[char] = upper
+			}
+		}
+	}
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		acm.weight[acm.index[eventIDAlphabet[i]]]++
+	}
+Patterns:
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		for i := 0; i < len(pattern); i++ {
+			if acm.index[pattern[i]] == -1 {
+				continue Patterns
+			}
+		}
+		node := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			next := &acm.nodes[node].children[acm.index[pattern[i]]]
+			if *next == 0 {
+				*next = int32(len(acm.nodes))
+				acm.nodes = append(acm.nodes, acNode{})
+			}
+			node = *next
+		}
+		acm.nodes[node].terminal = true
+		acm.patterns++
+	}
+	if anywhere {
+		acm.buildAutomaton()
+		acm.prob = acm.anywhereProbability()
+	} else {
+		acm.prob = acm.prefixProbability(0)
+	}
+	return acm
+}
+
+// buildAutomaton computes the failure links breadth-first and replaces missing children with
+// the transition of the failure node, turning the trie into a deterministic automaton.
+func (acm *AhoCorasickMatcher) buildAutomaton() {
+	fail := make([]int32, len(acm.nodes))
+	queue := make([]int32, 0, len(acm.nodes))
+	for _, child := range acm.nodes[0].children {
+		if child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if acm.nodes[fail[node]].terminal {
+			acm.nodes[node].terminal = true
+		}
+		for i, child := range acm.nodes[node].children {
+			if child == 0 {
+				acm.nodes[node].children[i] = acm.nodes[fail[node]].children[i]
+			} else {
+				fail[child] = acm.nodes[fail[node]].children[i]
+				queue = append(queue, child)
+			}
+		}
+	}
+}
+
+// prefixProbability returns the chance that a random event ID reaches a terminal node starting from the given node.
+// Patterns that are extensions of a shorter pattern are only counted once, as the shorter one already matches them.
+func (acm *AhoCorasickMatcher) prefixProbability(node int32) float64 {
+	if acm.nodes[node].terminal {
+		return 1
+	}
+	var p float64
+	for i, child := range acm.nodes[node].children {
+		if child != 0 {
+			p += float64(acm.weight[i]) / float64(len(eventIDAlphabet)) * acm.prefixProbability(child)
+		}
+	}
+	return p
+}
+
+// anywhereProbability computes the exact chance of a random event ID reaching a terminal state
+// by tracking the probability of being in each state after every position.
+func (acm *AhoCorasickMatcher) anywhereProbability() (matched float64) {
+	current := make([]float64, len(acm.nodes))
+	next := make([]float64, len(acm.nodes))
+	current[0] = 1
+	for pos := 0; pos < eventIDLength(); pos++ {
+		chars := eventIDCharsAt(pos)
+		charP := 1 / float64(len(chars))
+		clear(next)
+		for node, p := range current {
+			if p == 0 {
+				continue
+			}
+			for i := 0; i < len(chars); i++ {
+				nextNode := int32(0)
+				if idx := acm.index[chars[i]]; idx != -1 {
+					nextNode = acm.nodes[node].children[idx]
+				}
+				if acm.nodes[nextNode].terminal {
+					matched += p * charP
+				} else {
+					next[nextNode] += p * charP
+				}
+			}
+		}
+		current, next = next, current
+	}
+	return
+}
+
+func (acm *AhoCorasickMatcher) Match(eventID []byte) bool {
+	node := int32(0)
+	for _, char := range eventID {
+		idx := acm.index[char]
+		if idx == -1 {
+			if !acm.anywhere {
+				return false
+			}
+			node = 0
+			continue
+		}
+		if node = acm.nodes[node].children[idx]; acm.nodes[node].terminal {
+			return true
+		} else if node == 0 && !acm.anywhere {
+			return false
+		}
+	}
+	return false
+}
+
+func (acm *AhoCorasickMatcher) Probability() float64 {
+	return acm.prob
+}
+
+// MaskedPrefix returns the prefix shared by all the patterns in prefix mode.
+func (acm *AhoCorasickMatcher) MaskedPrefix() (value, mask []byte) {
+	if acm.anywhere {
+		return nil, nil
+	}
+	node := int32(0)
+	for !acm.nodes[node].terminal {
+		onlyChild, onlyIndex := int32(0), 0
+		for i, child := range acm.nodes[node].children {
+			if child == 0 {
+				continue
+			} else if onlyChild != 0 {
+				return
+			}
+			onlyChild, onlyIndex = child, i
+		}
+		if onlyChild == 0 {
+			return
+		}
+		char := eventIDAlphabet[onlyIndex]
+		if acm.weight[onlyIndex] == 2 {
+			value, mask = append(value, char&caseFoldMask), append(mask, caseFoldMask)
+		} else {
+			value, mask = append(value, char), append(mask, 0xff)
+		}
+		node = onlyChild
+	}
+	return
+}
+
+// Len returns the number of patterns in the automaton.
+func (acm *AhoCorasickMatcher) Len() int {
+	return acm.patterns
+}
+
+// readWordlist reads a list of words with one word per line, keeping only words with a length in the given range.
+// Lines starting with # are ignored.
+func readWordlist(path string, minLength, maxLength int) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if word := strings.TrimSpace(line); len(word) >= minLength && len(word) <= maxLength && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	return words, nil
+}
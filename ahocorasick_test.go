@@ -0,0 +1,93 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// naiveMatch is the obvious implementation of what AhoCorasickMatcher does.
+func naiveMatch(patterns []string, eventID string, ignoreCase, anywhere bool) bool {
+	if ignoreCase {
+		eventID = strings.ToUpper(eventID)
+	}
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern = strings.ToUpper(pattern)
+		}
+		if pattern == "" {
+			continue
+		} else if anywhere && strings.Contains(eventID, pattern) {
+			return true
+		} else if !anywhere && strings.HasPrefix(eventID, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAhoCorasickMatcher(t *testing.T) {
+	rng := newTestRand()
+	patterns := []string{"ab", "abc", "bca", "cab", "aaa", "a-_", "Zz", "zz9", "x!y", ""}
+	for _, mode := range []struct {
+		name                 string
+		ignoreCase, anywhere bool
+	}{
+		{"Prefix", false, false},
+		{"PrefixIgnoreCase", true, false},
+		{"Anywhere", false, true},
+		{"AnywhereIgnoreCase", true, true},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			acm := NewAhoCorasickMatcher(patterns, mode.ignoreCase, mode.anywhere)
+			if acm.patterns != len(patterns)-2 {
+				t.Errorf("expected the empty pattern and the one with invalid characters to be skipped, got %d patterns", acm.patterns)
+			}
+			// Use a small alphabet so that the patterns actually appear in the event IDs.
+			const chars = "abcABC-_zZ9!"
+			for range 50000 {
+				eventID := make([]byte, 2+rng.IntN(10))
+				for i := range eventID {
+					eventID[i] = chars[rng.IntN(len(chars))]
+				}
+				if got, expected := acm.Match(eventID), naiveMatch(patterns[:len(patterns)-2], string(eventID), mode.ignoreCase, mode.anywhere); got != expected {
+					t.Fatalf("matcher returned %t for %s, expected %t", got, eventID, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestAhoCorasickMaskedPrefix(t *testing.T) {
+	acm := NewAhoCorasickMatcher([]string{"abcd", "abce", "abx"}, false, false)
+	if value, mask := acm.MaskedPrefix(); string(value) != "ab" || string(mask) != "\xff\xff" {
+		t.Errorf("expected the shared prefix ab, got %q with mask %x", value, mask)
+	}
+	acm = NewAhoCorasickMatcher([]string{"abcd", "Abce"}, true, false)
+	if value, mask := acm.MaskedPrefix(); string(value) != "ABC" || string(mask) != string([]byte{caseFoldMask, caseFoldMask, caseFoldMask}) {
+		t.Errorf("expected the folded shared prefix ABC, got %q with mask %x", value, mask)
+	}
+	if value, _ := NewAhoCorasickMatcher([]string{"abcd"}, false, true).MaskedPrefix(); value != nil {
+		t.Errorf("expected no masked prefix in anywhere mode, got %q", value)
+	}
+}
+
+func TestAhoCorasickProbability(t *testing.T) {
+	// A pattern that extends another doesn't add anything, as the shorter one already matches.
+	acm := NewAhoCorasickMatcher([]string{"ab", "abc", "cd"}, false, false)
+	if p, expected := acm.Probability(), 2*charProbability(2); math.Abs(p-expected) > expected*1e-9 {
+		t.Errorf("expected prefix probability %g, got %g", expected, p)
+	}
+	// The exact probability is slightly lower than the estimate of ContainsMatcher, as the last character
+	// of an event ID only encodes 4 bits and can't be any character of the pattern.
+	acm = NewAhoCorasickMatcher([]string{"abcdef"}, false, true)
+	if p, expected := acm.Probability(), (ContainsMatcher("abcdef")).Probability(); p > expected || p < expected*0.95 {
+		t.Errorf("expected anywhere probability close to %g, got %g", expected, p)
+	}
+}
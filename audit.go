@@ -0,0 +1,276 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"go.mau.fi/util/exerrors"
+)
+
+// Integers in canonical JSON must be within the range that can be represented exactly as a double.
+const maxCanonicalInteger = 1<<53 - 1
+
+// auditCanonicalJSON encodes the JSON as canonical JSON using only the standard library. It's written directly
+// from the spec and shares no code with the canonicaljson package used for mining, so that the two implementations
+// can be compared with --audit.
+func auditCanonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeAuditCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeAuditCanonical(buf *bytes.Buffer, value any) error {
+	switch typed := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(typed))
+	case json.Number:
+		// Fractions, exponents and negative zero have no canonical form.
+		number, err := strconv.ParseInt(typed.String(), 10, 64)
+		if err != nil || strconv.FormatInt(number, 10) != typed.String() || number > maxCanonicalInteger || number < -maxCanonicalInteger {
+			return fmt.Errorf("%s is not an integer in the canonical JSON range", typed)
+		}
+		buf.WriteString(typed.String())
+	case string:
+		writeAuditCanonicalString(buf, typed)
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range typed {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeAuditCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		// Comparing UTF-8 bytes sorts the keys by code point, as required by the spec.
+		slices.Sort(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeAuditCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeAuditCanonical(buf, typed[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value %T", value)
+	}
+	return nil
+}
+
+// writeAuditCanonicalString writes a JSON string where only quotes, backslashes and control characters are escaped.
+// Like the reference implementation in the spec (Python's json.dumps), other control characters use lowercase hex.
+func writeAuditCanonicalString(buf *bytes.Buffer, str string) {
+	buf.WriteByte('"')
+	for _, char := range str {
+		switch char {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if char < 0x20 {
+				_, _ = fmt.Fprintf(buf, `\u%04x`, char)
+			} else {
+				buf.WriteRune(char)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// auditRedactionAlgorithms maps room versions to the room version whose redaction algorithm they use.
+var auditRedactionAlgorithms = map[string]int{
+	"1": 1, "2": 2, "3": 3, "4": 4, "5": 5, "6": 6, "7": 7, "8": 8, "9": 9, "10": 10, "11": 11,
+	"12": 11, "org.matrix.hydra.11": 11,
+}
+
+// auditRedact applies the redaction algorithm from the spec to the decoded event. It's transcribed separately
+// from the redactionRules tables used for mining, so that a mistake in either one shows up as a mismatch.
+func auditRedact(event map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var eventType, version string
+	_ = json.Unmarshal(event["type"], &eventType)
+	if eventType == "m.room.create" {
+		var content struct {
+			RoomVersion string `json:"room_version"`
+		}
+		_ = json.Unmarshal(event["content"], &content)
+		version = content.RoomVersion
+	} else if version = *roomVersion; version == "" {
+		version = defaultPDURoomVersion
+	}
+	algorithm, ok := auditRedactionAlgorithms[version]
+	if !ok && !*unstableRoomVersions {
+		return nil, fmt.Errorf("unknown room version %q", version)
+	} else if !ok {
+		algorithm = 11
+	}
+
+	redacted := make(map[string]json.RawMessage)
+	keep := func(keys ...string) {
+		for _, key := range keys {
+			if value, ok := event[key]; ok {
+				redacted[key] = value
+			}
+		}
+	}
+	keep("event_id", "type", "room_id", "sender", "state_key", "hashes", "signatures", "depth", "prev_events", "auth_events", "origin_server_ts")
+	if algorithm < 11 {
+		keep("origin", "membership", "prev_state")
+	}
+	rawContent, ok := event["content"]
+	if !ok {
+		return redacted, nil
+	}
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(rawContent, &content); err != nil {
+		return nil, fmt.Errorf("the content is not an object")
+	}
+	newContent := make(map[string]json.RawMessage)
+	keepContent := func(keys ...string) {
+		for _, key := range keys {
+			if value, ok := content[key]; ok {
+				newContent[key] = value
+			}
+		}
+	}
+	switch eventType {
+	case "m.room.create":
+		if algorithm >= 11 {
+			newContent = content
+		} else {
+			keepContent("creator")
+		}
+	case "m.room.member":
+		keepContent("membership")
+		if algorithm >= 9 {
+			keepContent("join_authorised_via_users_server")
+		}
+		if algorithm >= 11 {
+			var invite map[string]json.RawMessage
+			if json.Unmarshal(content["third_party_invite"], &invite) == nil && invite["signed"] != nil {
+				newContent["third_party_invite"] = exerrors.Must(json.Marshal(map[string]json.RawMessage{"signed": invite["signed"]}))
+			}
+		}
+	case "m.room.join_rules":
+		keepContent("join_rule")
+		if algorithm >= 8 {
+			keepContent("allow")
+		}
+	case "m.room.power_levels":
+		keepContent("ban", "events", "events_default", "kick", "redact", "state_default", "users", "users_default")
+		if algorithm >= 11 {
+			keepContent("invite")
+		}
+	case "m.room.history_visibility":
+		keepContent("history_visibility")
+	case "m.room.aliases":
+		if algorithm < 6 {
+			keepContent("aliases")
+		}
+	case "m.room.redaction":
+		if algorithm >= 11 {
+			keepContent("redacts")
+		}
+	}
+	redacted["content"] = exerrors.Must(json.Marshal(newContent))
+	return redacted, nil
+}
+
+// auditEventID independently recomputes the content hash and event ID of the event and returns an error if
+// either of them doesn't match what was found. Nothing is shared with the miner: canonical JSON, redaction,
+// hashing and removing the unhashed keys all have their own implementations.
+func auditEventID(pdu []byte, eventID string) error {
+	if !utf8.Valid(pdu) {
+		return fmt.Errorf("the event is not valid UTF-8")
+	}
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(pdu, &event); err != nil {
+		return fmt.Errorf("failed to parse event: %w", err)
+	}
+	delete(event, "signatures")
+	delete(event, "unsigned")
+	var hashes struct {
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.Unmarshal(event["hashes"], &hashes); err != nil || hashes.SHA256 == "" {
+		return fmt.Errorf("the event doesn't have a content hash")
+	}
+	withoutHashes := make(map[string]json.RawMessage, len(event))
+	for key, value := range event {
+		if key != "hashes" {
+			withoutHashes[key] = value
+		}
+	}
+	contentInput, err := auditCanonicalJSON(exerrors.Must(json.Marshal(withoutHashes)))
+	if err != nil {
+		return fmt.Errorf("failed to encode content hash input: %w", err)
+	}
+	contentHash := sha256.Sum256(contentInput)
+	if encoded := base64.RawStdEncoding.EncodeToString(contentHash[:]); encoded != hashes.SHA256 {
+		return fmt.Errorf("the event has the content hash %s, but a second implementation computed %s", hashes.SHA256, encoded)
+	}
+	redacted, err := auditRedact(event)
+	if err != nil {
+		return fmt.Errorf("failed to redact event: %w", err)
+	}
+	referenceInput, err := auditCanonicalJSON(exerrors.Must(json.Marshal(redacted)))
+	if err != nil {
+		return fmt.Errorf("failed to encode reference hash input: %w", err)
+	}
+	referenceHash := sha256.Sum256(referenceInput)
+	if encoded := eventIDEncoding.EncodeToString(referenceHash[:]); encoded != eventID {
+		return fmt.Errorf("the event ID was computed as %s, but a second implementation computed %s", eventID, encoded)
+	}
+	return nil
+}
+
+// auditCandidate checks the candidate with auditEventID if --audit is enabled.
+func auditCandidate(c *Candidate) error {
+	if !*audit {
+		return nil
+	}
+	return auditEventID(c.PDU, c.EventID)
+}
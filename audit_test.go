@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+)
+
+var auditRedactionEvents = []string{
+	`{"type":"m.room.create","sender":"@a:b","origin":"b","content":{"creator":"@a:b","room_version":"%s","m.federate":false},"unsigned":{"age":1},"hashes":{"sha256":"x"}}`,
+	`{"type":"m.room.member","state_key":"@a:b","membership":"invite","prev_state":[],"content":{"membership":"invite","displayname":"a","join_authorised_via_users_server":"@c:d","third_party_invite":{"display_name":"a","signed":{"token":"t"}}}}`,
+	`{"type":"m.room.member","state_key":"@a:b","content":{"membership":"join","third_party_invite":{"display_name":"a"}}}`,
+	`{"type":"m.room.power_levels","state_key":"","content":{"ban":50,"events":{},"events_default":0,"invite":0,"kick":50,"notifications":{"room":50},"redact":50,"state_default":50,"users":{"@a:b":100},"users_default":0}}`,
+	`{"type":"m.room.join_rules","state_key":"","content":{"join_rule":"restricted","allow":[]}}`,
+	`{"type":"m.room.history_visibility","state_key":"","content":{"history_visibility":"shared","other":1}}`,
+	`{"type":"m.room.aliases","state_key":"b","content":{"aliases":["#a:b"],"other":1}}`,
+	`{"type":"m.room.redaction","redacts":"$x","content":{"redacts":"$x","reason":"spam"}}`,
+	`{"type":"m.room.message","event_id":"$y","room_id":"!z","depth":5,"prev_events":[],"auth_events":[],"content":{"body":"meow"}}`,
+}
+
+// TestAuditRedact checks that the redaction algorithm of the audit agrees with the redaction rules used for mining.
+func TestAuditRedact(t *testing.T) {
+	oldVersion := *roomVersion
+	t.Cleanup(func() { *roomVersion = oldVersion })
+	for version := range roomVersions {
+		*roomVersion = version
+		for _, event := range auditRedactionEvents {
+			event = strings.ReplaceAll(event, "%s", version)
+			var decoded map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(event), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			audited, err := auditRedact(decoded)
+			if err != nil {
+				t.Fatalf("failed to redact %s in room version %s: %v", event, version, err)
+			}
+			expected := canonicaljson.CanonicalJSONAssumeValid(redactPDU([]byte(event), redactionRulesOf([]byte(event))))
+			if got := canonicaljson.CanonicalJSONAssumeValid(exerrors.Must(json.Marshal(audited))); string(got) != string(expected) {
+				t.Errorf("redactions of %s in room version %s disagree\nminer %s\naudit %s", event, version, expected, got)
+			}
+		}
+	}
+}
+
+func TestAuditEventID(t *testing.T) {
+	tpl := NewCreateTemplate("@meow:example.com", 1735689600000, json.RawMessage(`{"room_version":"12"}`))
+	found := mineTemplate(tpl, CompileMatcher(PrefixMatcher("A")), 1, time.After(10*time.Second))
+	if len(found) == 0 {
+		t.Fatal("didn't find an event")
+	}
+	c := found[0]
+	if err := auditEventID(c.PDU, c.EventID); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditEventID(c.PDU, "A"+c.EventID[1:]+"x"); err == nil {
+		t.Error("expected a wrong event ID to be rejected")
+	}
+	tampered := exerrors.Must(sjson.SetBytes(c.PDU, "content.m\\.federate", false))
+	if err := auditEventID(tampered, c.EventID); err == nil {
+		t.Error("expected changed content to be rejected")
+	}
+	// Signatures and unsigned data aren't covered by either hash.
+	signed := exerrors.Must(sjson.SetBytes(c.PDU, "unsigned.age", 1))
+	if err := auditEventID(signed, c.EventID); err != nil {
+		t.Errorf("unsigned data shouldn't affect the audit: %v", err)
+	}
+}
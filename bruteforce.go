@@ -0,0 +1,391 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// Candidate is an event ID that was accepted by a matcher.
+type Candidate struct {
+	ThreadID uint16
+	// The number of hashes the thread had checked when it found the candidate.
+	Hashes uint64
+	// The time since the thread was started.
+	Duration time.Duration
+	EventID  string
+	// The canonical JSON of the full create event, including the content hash.
+	PDU []byte
+	// Whether the candidate was loaded from the result cache rather than found by a worker.
+	Cached bool
+	// For near-miss candidates, the number of leading characters that matched the target.
+	MatchedLength int
+}
+
+func (c *Candidate) RoomID() id.RoomID {
+	return id.RoomID(*roomIDSigil + c.EventID)
+}
+
+// Sender returns the creator of the candidate create event, which can be any of the user IDs given with -u.
+func (c *Candidate) Sender() id.UserID {
+	return id.UserID(gjson.GetBytes(c.PDU, "sender").Str)
+}
+
+// How often workers publish their hash counters. Must be a power of two.
+const hashPublishInterval = 1 << 10
+
+// Worker runs the bruteforce loop on a single thread.
+type Worker struct {
+	ThreadID  uint16
+	Template  *Template
+	Matcher   *CompiledMatcher
+	ChunkSize uint32
+	// The counter value to start from, used when continuing the range of another worker.
+	StartCounter uint64
+	// If set, the worker stops when it reaches this counter value instead of moving on to the next timestamp.
+	// Used for replacements of stragglers that only cover a part of the remaining range.
+	EndCounter uint64
+	// OnFound is called for every candidate accepted by the matcher. If it returns false, the worker stops.
+	OnFound func(*Candidate) bool
+	// If set, the worker keeps track of the candidate that matched the longest part of this target.
+	BestEffortTarget []byte
+	// If set, near-miss candidates are scored with the named scorer instead of how much of BestEffortTarget they match.
+	Scorer string
+	// The number of best near-miss candidates to keep track of in addition to the single best one.
+	// Requires BestEffortTarget or Scorer to be set.
+	TopCandidates int
+	// If OnNearMiss is set, it's called for every candidate that scored at least NearMissLength,
+	// including full matches. Requires BestEffortTarget or Scorer to be set.
+	NearMissLength int
+	OnNearMiss     func(*Candidate)
+	// If set, the bruteforce loop is run in a child process instead of a goroutine.
+	Process bool
+	// The CPU to pin the worker to, or -1 to not pin. Only supported for child processes on Linux.
+	Affinity int
+
+	hashes atomic.Uint64
+	// The first counter value that hasn't been checked at the current timestamp, published along with hashes.
+	nextCounter atomic.Uint64
+	// The position of the current timestamp in the window, published whenever the worker moves on to the next one.
+	timestampPosition atomic.Int64
+	stop              atomic.Bool
+	best              atomic.Pointer[Candidate]
+	top               atomic.Pointer[[]*Candidate]
+	done              chan struct{}
+}
+
+func newWorker(threadID uint16, tpl *Template, matcher *CompiledMatcher, onFound func(*Candidate) bool) *Worker {
+	return &Worker{
+		ThreadID:  threadID,
+		Template:  tpl.Clone(),
+		Matcher:   matcher,
+		ChunkSize: *logInterval,
+		OnFound:   onFound,
+		Affinity:  -1,
+		done:      make(chan struct{}),
+	}
+}
+
+// newWorkers creates the workers for a round, spreading them evenly over the templates of each sender.
+func newWorkers(tpls []*Template, matcher *CompiledMatcher, onFound func(*Candidate) bool) []*Worker {
+	workers := make([]*Worker, *threadCount)
+	threadIDs := make(map[uint16]struct{}, len(workers))
+	for i := range workers {
+		threadID := *threadIndexStart + uint16(i)
+		if *randomThreadIDs {
+			// Thread IDs must still be unique within this instance, otherwise threads would duplicate each other's work.
+			for threadID = randomUint16(); ; threadID = randomUint16() {
+				if _, used := threadIDs[threadID]; !used {
+					break
+				}
+			}
+			threadIDs[threadID] = struct{}{}
+		}
+		tpl := tpls[i%len(tpls)]
+		workers[i] = newWorker(threadID, tpl, matcher, onFound)
+		if *randomStart {
+			workers[i].StartCounter = randomUint64()
+			if end := tpl.counterEnd(); end != 0 {
+				workers[i].StartCounter %= end
+			}
+		}
+		if tpl.RandomnessLength == 0 {
+			// Without randomness, threads of the same sender can only be told apart by the timestamps they use.
+			workers[i].Template.TimestampStep = int64((len(workers) - i%len(tpls) + len(tpls) - 1) / len(tpls))
+			workers[i].Template.setTimestampPosition(int64(i / len(tpls)))
+		}
+	}
+	return workers
+}
+
+// startWorkers starts the given workers in the background, staggering their start times over half a second.
+func startWorkers(workers []*Worker, wg *sync.WaitGroup) {
+	wg.Add(len(workers))
+	for _, w := range workers {
+		go w.Run(wg.Done)
+		time.Sleep(time.Duration(500/len(workers)) * time.Millisecond)
+	}
+}
+
+// totalHashes returns the approximate number of hashes checked by all the given workers.
+func totalHashes(workers []*Worker) (total uint64) {
+	for _, w := range workers {
+		total += w.Hashes()
+	}
+	return
+}
+
+func (w *Worker) Hashes() uint64 {
+	return w.hashes.Load()
+}
+
+// Best returns the best near-miss candidate the worker has seen, or nil if near-miss tracking isn't enabled.
+func (w *Worker) Best() *Candidate {
+	return w.best.Load()
+}
+
+// Top returns the best TopCandidates near-miss candidates the worker has seen, in no particular order.
+func (w *Worker) Top() []*Candidate {
+	if top := w.top.Load(); top != nil {
+		return *top
+	}
+	return nil
+}
+
+// bestCandidate returns the best near-miss candidate across all the given workers.
+func bestCandidate(workers []*Worker) (best *Candidate) {
+	for _, w := range workers {
+		if c := w.Best(); c != nil && (best == nil || c.MatchedLength > best.MatchedLength) {
+			best = c
+		}
+	}
+	return
+}
+
+// Stop asks the worker to stop. The worker will exit within a few thousand hashes.
+func (w *Worker) Stop() {
+	w.stop.Store(true)
+}
+
+// Done returns a channel that is closed when the worker exits.
+func (w *Worker) Done() <-chan struct{} {
+	return w.done
+}
+
+// NextCounter returns the first counter value the worker hasn't checked at its current timestamp.
+// It's updated along with Hashes, so it's only exact after the worker has exited.
+func (w *Worker) NextCounter() uint64 {
+	return w.nextCounter.Load()
+}
+
+// TimestampPosition returns the position of the timestamp the worker is currently using in the window.
+func (w *Worker) TimestampPosition() int64 {
+	return w.timestampPosition.Load()
+}
+
+// Exited returns true if the worker has exited, either because it was stopped or because it ran out of events to check.
+func (w *Worker) Exited() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func stopWorkers(workers []*Worker) {
+	for _, w := range workers {
+		w.Stop()
+	}
+}
+
+func (w *Worker) Run(doneFunc func()) {
+	defer doneFunc()
+	defer close(w.done)
+	w.nextCounter.Store(w.StartCounter)
+	w.timestampPosition.Store(w.Template.TimestampPosition)
+	if w.Process {
+		w.runProcess()
+		return
+	}
+	threadID, matcher, chunkSize := w.ThreadID, w.Matcher, w.ChunkSize
+	pduJSON, pduJSONWithHashField := w.Template.PDU, w.Template.PDUWithHash
+	pduRandomIndex := w.Template.RandomOffset
+	pduHashRandomIndex := w.Template.RandomOffsetWithHash
+	pduHashIndex := w.Template.HashOffset
+
+	var i, chunks uint32
+	randomnessLength := w.Template.RandomnessLength
+	// 2 byte thread ID + 64-bit counter. The counter is incremented as a native uint64 for speed,
+	// so the buffer always has room for it, even if the randomness is shorter and it isn't all encoded.
+	// Bytes after the counter in randomness longer than 10 bytes are left as zeroes.
+	randomness := make([]byte, max(randomnessLength, 10))
+	binary.BigEndian.PutUint16(randomness[0:2], threadID)
+	unsafeRandomnessUint64 := (*uint64)(unsafe.Pointer(&randomness[2]))
+	*unsafeRandomnessUint64 = w.StartCounter
+	counterEnd := w.Template.counterEnd()
+	if w.EndCounter != 0 {
+		counterEnd = w.EndCounter
+	}
+	encodedRandomness := randomness[:randomnessLength]
+	randomnessEncodedLength := base64.RawURLEncoding.EncodedLen(randomnessLength)
+	pduRandomSlot := pduJSON[pduRandomIndex : pduRandomIndex+randomnessEncodedLength]
+	// If redaction removes the randomness, it only affects the event ID through the content hash.
+	var pduWithHashRandomSlot []byte
+	if pduHashRandomIndex >= 0 {
+		pduWithHashRandomSlot = pduJSONWithHashField[pduHashRandomIndex : pduHashRandomIndex+randomnessEncodedLength]
+	}
+	pduHashSlot := pduJSONWithHashField[pduHashIndex : pduHashIndex+base64SHA256Length]
+	// candidatePDU returns the full event for a candidate, which is only different from the hashed one if it was redacted.
+	candidatePDU := func() []byte {
+		if w.Template.Redacted {
+			return w.Template.FullPDU(pduHashSlot)
+		}
+		return bytes.Clone(pduJSONWithHashField)
+	}
+
+	hasher := sha256.New()
+	hashContainer := make([]byte, sha256.Size)
+	eventID := make([]byte, eventIDEncoding.EncodedLen(sha256.Size))
+
+	bestEffortTarget := w.BestEffortTarget
+	if len(bestEffortTarget) == 0 {
+		bestEffortTarget = nil
+	}
+	var score func(eventID []byte) int
+	if w.Scorer != "" {
+		bestEffortTarget = nil
+		score = scorers[w.Scorer]
+	} else if bestEffortTarget != nil {
+		score = func(eventID []byte) int { return commonPrefixLength(eventID, bestEffortTarget) }
+	}
+	bestLength := -1
+	var top candidateHeap
+	topCount := w.TopCandidates
+
+	start := time.Now()
+	lastChunk := start
+	for {
+		i++
+		base64.RawURLEncoding.Encode(pduRandomSlot, encodedRandomness)
+		copy(pduWithHashRandomSlot, pduRandomSlot)
+		hasher.Reset()
+		hasher.Write(pduJSON)
+		hasher.Sum(hashContainer[:0])
+		base64.RawStdEncoding.Encode(pduHashSlot, hashContainer)
+		// With a content hash prefix, the reference hash only needs to be computed if the content hash matches.
+		if matcher.MatchContentHash(hashContainer) {
+			hasher.Reset()
+			hasher.Write(pduJSONWithHashField)
+			hasher.Sum(hashContainer[:0])
+			// Near-miss tracking needs every event ID, so hashes can only be rejected before encoding without it.
+			if score != nil || matcher.MatchRaw(hashContainer) {
+				eventIDEncoding.Encode(eventID, hashContainer)
+				// Near-misses are tracked first so that a match is also included in the top candidates.
+				// With a target, anything that doesn't share the first character can't improve on what's already been seen.
+				if score != nil && (bestEffortTarget == nil || eventID[0] == bestEffortTarget[0] || bestLength < 0 || len(top) < topCount) {
+					n := score(eventID)
+					if n > bestLength || top.accepts(n, topCount) || (w.OnNearMiss != nil && n >= w.NearMissLength) {
+						c := &Candidate{
+							ThreadID:      threadID,
+							Hashes:        uint64(chunks)*uint64(chunkSize) + uint64(i),
+							Duration:      time.Since(start),
+							EventID:       string(eventID),
+							PDU:           candidatePDU(),
+							MatchedLength: n,
+						}
+						if n > bestLength {
+							bestLength = n
+							w.best.Store(c)
+						}
+						if top.accepts(n, topCount) {
+							top.offer(c, topCount)
+							snapshot := slices.Clone([]*Candidate(top))
+							w.top.Store(&snapshot)
+						}
+						if w.OnNearMiss != nil && n >= w.NearMissLength {
+							w.OnNearMiss(c)
+						}
+					}
+				}
+				if matcher.Match(eventID) {
+					hashes := uint64(chunks)*uint64(chunkSize) + uint64(i)
+					w.hashes.Store(hashes)
+					if !w.OnFound(&Candidate{
+						ThreadID: threadID,
+						Hashes:   hashes,
+						Duration: time.Since(start),
+						EventID:  string(eventID),
+						PDU:      candidatePDU(),
+					}) {
+						*unsafeRandomnessUint64++
+						break
+					}
+				}
+			}
+		}
+		if i == chunkSize {
+			dur := time.Since(lastChunk)
+			_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "checkpoint", chunks, "checked", chunkSize, "hashes,", (dur / time.Duration(chunkSize)).String(), "per hash")
+			i = 0
+			chunks++
+			w.hashes.Store(uint64(chunks) * uint64(chunkSize))
+			lastChunk = time.Now()
+		}
+		if *unsafeRandomnessUint64++; *unsafeRandomnessUint64 == counterEnd {
+			if w.EndCounter != 0 {
+				_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "finished its part of the counter range after", time.Since(start).String())
+				break
+			}
+			*unsafeRandomnessUint64 = 0
+			// The randomness slot is shared by all timestamps, so the whole counter range is available again.
+			if w.Template.nextTimestamp() {
+				w.timestampPosition.Store(w.Template.TimestampPosition)
+				// Without randomness, every hash has its own timestamp, so this isn't worth logging.
+				if randomnessLength > 0 {
+					_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "exhausted its counter range, moving on to timestamp", w.Template.Timestamp)
+				}
+			} else {
+				_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "exhausted its search space after", time.Since(start).String())
+				break
+			}
+		}
+		// This is done after moving on to the next counter, so that both numbers are exact if the worker stops here.
+		if i&(hashPublishInterval-1) == 0 {
+			w.nextCounter.Store(*unsafeRandomnessUint64)
+			w.hashes.Store(uint64(chunks)*uint64(chunkSize) + uint64(i))
+			if w.stop.Load() {
+				break
+			}
+		}
+	}
+	w.nextCounter.Store(*unsafeRandomnessUint64)
+	w.hashes.Store(uint64(chunks)*uint64(chunkSize) + uint64(i))
+}
+
+// randomUint64 returns a random number from crypto/rand, used for --random-start.
+func randomUint64() uint64 {
+	return binary.BigEndian.Uint64(randomBytes(8))
+}
+
+func randomUint16() uint16 {
+	return uint16(randomUint64())
+}
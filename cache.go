@@ -0,0 +1,136 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+// cacheSpec contains everything that affects which create event will be found.
+type cacheSpec struct {
+	Sender id.UserID `json:"sender"`
+	// Only set when searching over multiple senders, so that existing cache entries stay valid.
+	OtherSenders []id.UserID     `json:"other_senders,omitempty"`
+	Content      json.RawMessage `json:"content"`
+	Timestamp    int64           `json:"timestamp"`
+	Prefix       string          `json:"prefix"`
+	IDAlphabet   string          `json:"id_alphabet"`
+	IDPadding    bool            `json:"id_padding"`
+	// The rest are only set if they're not the default, so that existing cache entries stay valid.
+	RandomnessField  string `json:"randomness_field,omitempty"`
+	NoRandomness     bool   `json:"no_randomness,omitempty"`
+	RandomnessLength int    `json:"randomness_length,omitempty"`
+	TimestampWindow  int64  `json:"timestamp_window,omitempty"`
+
+	Suffix            string `json:"suffix,omitempty"`
+	ContentHashPrefix string `json:"content_hash_prefix,omitempty"`
+	Contains          string `json:"contains,omitempty"`
+	Regex             string `json:"regex,omitempty"`
+	Structure         string `json:"structure,omitempty"`
+	Pronounceable     int    `json:"pronounceable,omitempty"`
+	Score             string `json:"score,omitempty"`
+	Charset           string `json:"charset,omitempty"`
+	Blocklist         string `json:"blocklist,omitempty"`
+	MatchExpr         string `json:"match_expr,omitempty"`
+	MatchScript       string `json:"match_script,omitempty"`
+	PrefixFile        string `json:"prefix_file,omitempty"`
+	Wordlist          string `json:"wordlist,omitempty"`
+	WordlistAnywhere  bool   `json:"wordlist_anywhere,omitempty"`
+	MinWordLength     int    `json:"min_word_length,omitempty"`
+	IgnoreCase        bool   `json:"ignore_case,omitempty"`
+	FuzzyGlyphs       bool   `json:"fuzzy_glyphs,omitempty"`
+	Leet              bool   `json:"leet,omitempty"`
+}
+
+// cacheSpecHash returns a hex-encoded hash of the cacheSpec for the current flags.
+func cacheSpecHash(senders []id.UserID, content json.RawMessage) string {
+	spec := &cacheSpec{
+		Sender:       senders[0],
+		OtherSenders: senders[1:],
+		Content:      content,
+		Timestamp:    *timestamp,
+		Prefix:       *prefix,
+		IDAlphabet:   eventIDAlphabet,
+		IDPadding:    *idPadding,
+
+		NoRandomness:    *noRandomness,
+		TimestampWindow: *timestampWindow,
+
+		Suffix:            *suffix,
+		ContentHashPrefix: *contentHashPrefix,
+		Contains:          *contains,
+		Regex:             *regexPattern,
+		Structure:         *structure,
+		Pronounceable:     *pronounceable,
+		Score:             *scoreMode,
+		Charset:           *charset,
+		Blocklist:         *blocklist,
+		MatchExpr:         *matchExpr,
+		MatchScript:       *matchScript,
+		PrefixFile:        *prefixFile,
+		Wordlist:          *wordlist,
+		WordlistAnywhere:  *wordlistAnywhere,
+		IgnoreCase:        *ignoreCase,
+		FuzzyGlyphs:       *fuzzyGlyphs,
+		Leet:              *leet,
+	}
+	if *randomnessFieldPath != randomnessField {
+		spec.RandomnessField = *randomnessFieldPath
+	}
+	if !*noRandomness && *randomnessLength != defaultRandomnessLength {
+		spec.RandomnessLength = *randomnessLength
+	}
+	if *wordlist != "" {
+		spec.MinWordLength = *minWordLength
+	}
+	hash := sha256.Sum256(canonicaljson.CanonicalJSONAssumeValid(exerrors.Must(json.Marshal(spec))))
+	return hex.EncodeToString(hash[:])
+}
+
+func cachePath(senders []id.UserID, content json.RawMessage) string {
+	return filepath.Join(*cacheDir, cacheSpecHash(senders, content)+".json")
+}
+
+// loadCachedResult returns a previously found create event for the same job spec, or nil if there isn't one.
+func loadCachedResult(path string, matcher *CompiledMatcher) *Candidate {
+	pdu, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to read cached result:", err)
+		return nil
+	}
+	breakdown := NewHashBreakdown(pdu)
+	eventID := breakdown.ReferenceHash
+	contentHash, _ := base64.RawStdEncoding.DecodeString(breakdown.ContentHash)
+	if !matcher.Match([]byte(eventID)) || !matcher.MatchContentHash(contentHash) {
+		_, _ = fmt.Fprintln(os.Stderr, "Ignoring cached result", path, "as it doesn't match the prefix")
+		return nil
+	}
+	return &Candidate{EventID: eventID, PDU: pdu, Cached: true}
+}
+
+func storeCachedResult(path string, c *Candidate) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(path, c.PDU, 0600)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to store result in cache:", err)
+	}
+}
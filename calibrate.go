@@ -0,0 +1,130 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const calibrationLength = 5
+
+// Deviations larger than this many standard deviations are treated as a sign of a biased pipeline.
+const calibrationMaxSigma = 4
+
+const defaultCalibrationSender = "@calibration:example.com"
+
+// calibrationMatcher counts how many event IDs match each prefix of the calibration target.
+// It never accepts anything, so the workers keep hashing until the calibration time is up.
+type calibrationMatcher struct {
+	prefixes [calibrationLength]*CompiledMatcher
+	total    atomic.Uint64
+	matches  [calibrationLength]atomic.Uint64
+}
+
+func newCalibrationMatcher(target string) *calibrationMatcher {
+	cm := &calibrationMatcher{}
+	for i := range cm.prefixes {
+		cm.prefixes[i] = CompileMatcher(PrefixMatcher(target[:i+1]))
+	}
+	return cm
+}
+
+func (cm *calibrationMatcher) Match(eventID []byte) bool {
+	cm.total.Add(1)
+	for i, pm := range cm.prefixes {
+		if !pm.Match(eventID) {
+			break
+		}
+		cm.matches[i].Add(1)
+	}
+	return false
+}
+
+func (cm *calibrationMatcher) Probability() float64 {
+	return 0
+}
+
+func runCalibrate() {
+	sender := id.UserID(*creator)
+	if sender == "" {
+		sender = defaultCalibrationSender
+		// The content was rendered before the default sender was picked.
+		var err error
+		if *createContent, err = renderCreateContent(sender, *timestamp); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	if _, _, err := sender.Parse(); err != nil {
+		fatalf(ExitInvalidInput, "Invalid user ID: %s", sender)
+	} else if len(senders()) > 1 {
+		fatalf(ExitInvalidInput, "Calibration only supports a single user ID")
+	} else if !json.Valid([]byte(*createContent)) {
+		fatalf(ExitInvalidInput, "Invalid create event content")
+	} else if *maxSeconds <= 0 {
+		fatalf(ExitInvalidInput, "Calibration needs a positive time limit")
+	} else if int(*threadIndexStart)+int(*threadCount) > math.MaxUint16 {
+		fatalf(ExitInvalidInput, "Thread index %d + %d exceeds uint16 limit", *threadIndexStart, *threadCount)
+	} else if err := checkRoomVersion(*createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkTemplateFlags(*timestamp, *createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	target := []byte(firstTargetPrefix())
+	for len(target) < calibrationLength {
+		target = append(target, eventIDAlphabet[rand.IntN(len(eventIDAlphabet))])
+	}
+	target = target[:calibrationLength]
+
+	tpl := NewCreateTemplate(sender, *timestamp, json.RawMessage(*createContent))
+	workers := newWorkers([]*Template{tpl}, nil, nil)
+	matchers := make([]*calibrationMatcher, len(workers))
+	for i, w := range workers {
+		matchers[i] = newCalibrationMatcher(string(target))
+		w.Matcher = CompileMatcher(matchers[i])
+	}
+	startWorkers(workers, &sync.WaitGroup{})
+	timeLimit := time.Duration(*maxSeconds) * time.Second
+	_, _ = fmt.Fprintln(os.Stderr, "Calibrating for", timeLimit, "with target", string(target))
+	time.Sleep(timeLimit)
+
+	var total uint64
+	var matches [calibrationLength]uint64
+	for _, cm := range matchers {
+		total += cm.total.Load()
+		for i := range matches {
+			matches[i] += cm.matches[i].Load()
+		}
+	}
+	fmt.Printf("Checked %d hashes in %s (%.0f hashes/s)\n", total, timeLimit, float64(total)/timeLimit.Seconds())
+	fmt.Printf("%-6s  %-6s  %10s  %12s  %9s\n", "Length", "Target", "Observed", "Expected", "Deviation")
+	passed := true
+	for i, observed := range matches {
+		p := charProbability(i + 1)
+		expected := float64(total) * p
+		sigma := (float64(observed) - expected) / math.Sqrt(expected*(1-p))
+		status := ""
+		if math.Abs(sigma) > calibrationMaxSigma {
+			status = " (!)"
+			passed = false
+		}
+		fmt.Printf("%-6d  %-6s  %10d  %12.2f  %+8.2fσ%s\n", i+1, target[:i+1], observed, expected, sigma, status)
+	}
+	if !passed {
+		fatalf(ExitNotFound, "Calibration failed: observed counts deviate more than %dσ from the expected values", calibrationMaxSigma)
+	}
+	fmt.Println("Calibration passed")
+	os.Exit(ExitFound)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// memberPDU is the initial join event of the room creator, which is the second event in the room.
+type memberPDU struct {
+	AuthEvents     []id.EventID    `json:"auth_events"`
+	PrevEvents     []id.EventID    `json:"prev_events"`
+	Depth          int             `json:"depth"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	RoomID         id.RoomID       `json:"room_id"`
+	Sender         id.UserID       `json:"sender"`
+	StateKey       string          `json:"state_key"`
+	Type           string          `json:"type"`
+	Content        json.RawMessage `json:"content"`
+}
+
+// newChainedMemberPDU returns the join event of the creator that follows the given create event.
+// The create event isn't listed in auth_events, as it's implied by the room ID when the room ID is derived from it.
+func newChainedMemberPDU(create *Candidate) ([]byte, int64) {
+	ts := gjson.GetBytes(create.PDU, "origin_server_ts").Int() + 1
+	return exerrors.Must(json.Marshal(&memberPDU{
+		AuthEvents:     []id.EventID{},
+		PrevEvents:     []id.EventID{id.EventID("$" + create.EventID)},
+		Depth:          2,
+		OriginServerTS: ts,
+		RoomID:         create.RoomID(),
+		Sender:         create.Sender(),
+		StateKey:       create.Sender().String(),
+		Type:           "m.room.member",
+		Content:        json.RawMessage(`{"membership":"join"}`),
+	})), ts
+}
+
+// mineChainedMember bruteforces the creator's join event for the given create event with the same matcher,
+// so that the first two event IDs in the room both match. Returns nil if the time limit is reached first.
+func mineChainedMember(create *Candidate, matcher *CompiledMatcher) *Candidate {
+	pdu, ts := newChainedMemberPDU(create)
+	_, _ = fmt.Fprintln(os.Stderr, "Mining join event of", create.Sender(), "in", create.RoomID())
+	var deadline <-chan time.Time
+	if *maxSeconds >= 0 {
+		deadline = time.After(time.Duration(*maxSeconds) * time.Second)
+	}
+	if found := mineTemplate(newTemplate(pdu, ts), matcher, 1, deadline); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// mineTemplate runs a round of workers on the given template until they've found n distinct candidates,
+// the deadline is reached or the workers run out of events to check.
+// Returns the candidates in the order they were found.
+func mineTemplate(tpl *Template, matcher *CompiledMatcher, n int, deadline <-chan time.Time) []*Candidate {
+	found, _ := mineTemplateCounted(tpl, matcher, n, deadline)
+	return found
+}
+
+// mineTemplateCounted is mineTemplate, but also returns the total number of hashes the workers checked.
+func mineTemplateCounted(tpl *Template, matcher *CompiledMatcher, n int, deadline <-chan time.Time) ([]*Candidate, uint64) {
+	var lock sync.Mutex
+	var found []*Candidate
+	seen := make(map[string]struct{})
+	done := make(chan struct{})
+	workers := newWorkers([]*Template{tpl}, matcher, func(c *Candidate) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if _, dup := seen[c.EventID]; dup || len(found) >= n {
+			return len(found) < n
+		}
+		seen[c.EventID] = struct{}{}
+		found = append(found, c)
+		if len(found) == n {
+			close(done)
+			return false
+		}
+		return true
+	})
+	var wg sync.WaitGroup
+	startWorkers(workers, &wg)
+	exhausted := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exhausted)
+	}()
+	select {
+	case <-done:
+	case <-exhausted:
+	case <-deadline:
+	}
+	stopWorkers(workers)
+	lock.Lock()
+	defer lock.Unlock()
+	return slices.Clone(found), totalHashes(workers)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	asciiUppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	asciiLowercase = "abcdefghijklmnopqrstuvwxyz"
+	asciiDigits    = "0123456789"
+)
+
+// charsets are the named sets of characters that can be required for the whole event ID with --charset.
+var charsets = map[string]string{
+	"letters-only": asciiUppercase + asciiLowercase,
+	"alphanumeric": asciiUppercase + asciiLowercase + asciiDigits,
+	"lowercase":    asciiLowercase + asciiDigits,
+	"uppercase":    asciiUppercase + asciiDigits,
+}
+
+// CharsetMatcher accepts event IDs that only contain characters from a set.
+type CharsetMatcher struct {
+	allowed [256]bool
+}
+
+// NewCharsetMatcher returns a matcher for the named charset.
+func NewCharsetMatcher(name string) (*CharsetMatcher, error) {
+	chars, ok := charsets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown charset %q, supported charsets are %s", name, strings.Join(slices.Sorted(maps.Keys(charsets)), ", "))
+	}
+	cm := &CharsetMatcher{}
+	for i := 0; i < len(chars); i++ {
+		cm.allowed[chars[i]] = true
+	}
+	return cm, nil
+}
+
+func (cm *CharsetMatcher) Match(eventID []byte) bool {
+	for _, char := range eventID {
+		if !cm.allowed[char] {
+			return false
+		}
+	}
+	return true
+}
+
+func (cm *CharsetMatcher) Probability() float64 {
+	p := 1.0
+	for pos := 0; pos < eventIDLength(); pos++ {
+		chars := eventIDCharsAt(pos)
+		var allowed int
+		for i := 0; i < len(chars); i++ {
+			if cm.allowed[chars[i]] {
+				allowed++
+			}
+		}
+		p *= float64(allowed) / float64(len(chars))
+	}
+	return p
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+// loadCreateContent replaces the -c value with the contents of a file if it's @path, or stdin if it's -.
+// Neither can be confused with inline content, as JSON objects can't start with @ or -.
+func loadCreateContent() error {
+	var data []byte
+	var err error
+	if *createContent == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read create event content from stdin: %w", err)
+		}
+	} else if path, ok := strings.CutPrefix(*createContent, "@"); ok {
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read create event content: %w", err)
+		}
+	} else {
+		return nil
+	}
+	*createContent = string(data)
+	return nil
+}
+
+// The create content as given with -c, after loading it from a file or stdin. Set after parsing flags.
+// The -c value itself is replaced with the content rendered for the first sender.
+var createContentSource string
+
+// The parsed -c value if it contains template actions, see renderCreateContent.
+var contentTemplate *template.Template
+
+// contentTemplateData contains the variables available in create content templates.
+type contentTemplateData struct {
+	Sender     id.UserID
+	Timestamp  int64
+	ServerName string
+}
+
+// parseContentTemplate parses the create content as a template if it contains any template actions.
+func parseContentTemplate() (err error) {
+	createContentSource = *createContent
+	contentTemplate = nil
+	if !strings.Contains(createContentSource, "{{") {
+		return nil
+	}
+	contentTemplate, err = template.New("content").Option("missingkey=error").Parse(createContentSource)
+	if err != nil {
+		return fmt.Errorf("failed to parse create event content template: %w", err)
+	}
+	return nil
+}
+
+// renderCreateContent returns the create content for the given sender and timestamp. The content template is
+// rendered first, and the room version, predecessor and additional creators are then added to the result.
+// The validity of the JSON is only checked if one of those flags is used, as commands that don't use the content
+// shouldn't fail because of it.
+func renderCreateContent(sender id.UserID, ts int64) (string, error) {
+	content := createContentSource
+	if contentTemplate != nil {
+		var buf bytes.Buffer
+		err := contentTemplate.Execute(&buf, &contentTemplateData{Sender: sender, Timestamp: ts, ServerName: sender.Homeserver()})
+		if err != nil {
+			return "", fmt.Errorf("failed to render create event content template: %w", err)
+		}
+		content = buf.String()
+	}
+	content, err := applyRoomVersion(content)
+	if err != nil {
+		return "", err
+	}
+	content, err = applyPredecessor(content)
+	if err != nil {
+		return "", err
+	}
+	content, err = applyAdditionalCreators(content)
+	if err != nil || !gjson.Valid(content) {
+		return content, err
+	}
+	normalized, err := normalizeJSON([]byte(content))
+	if err != nil {
+		return "", fmt.Errorf("invalid create event content: %w", err)
+	}
+	return string(normalized), nil
+}
+
+// normalizeJSON returns the canonical form of the given valid JSON, so that escaped characters are already decoded
+// when templates are made. Invalid UTF-8 and unpaired UTF-16 surrogate escapes are rejected, as the canonical JSON
+// encoder can't represent them, and homeservers would either reject them or hash something else.
+func normalizeJSON(data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("contains invalid UTF-8")
+	}
+	// Backslashes can only appear in strings, so escapes can be found without parsing the JSON.
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' {
+			continue
+		}
+		i++
+		if data[i] != 'u' {
+			continue
+		}
+		r := parseHexEscape(data[i+1 : i+5])
+		i += 4
+		if !utf16.IsSurrogate(r) {
+			continue
+		} else if r < 0xdc00 && i+6 < len(data) && data[i+1] == '\\' && data[i+2] == 'u' {
+			if low := parseHexEscape(data[i+3 : i+7]); low >= 0xdc00 && low < 0xe000 {
+				i += 6
+				continue
+			}
+		}
+		return nil, fmt.Errorf("contains an unpaired surrogate escape \\u%s", data[i-3:i+1])
+	}
+	return canonicaljson.CanonicalJSONAssumeValid(data), nil
+}
+
+func parseHexEscape(hex []byte) rune {
+	val, _ := strconv.ParseUint(string(hex), 16, 16)
+	return rune(val)
+}
+
+// newSenderTemplates creates a create event template for each of the given senders.
+func newSenderTemplates(senders []id.UserID, ts int64) ([]*Template, error) {
+	tpls := make([]*Template, len(senders))
+	for i, sender := range senders {
+		content, err := renderCreateContent(sender, ts)
+		if err != nil {
+			return nil, err
+		} else if !json.Valid([]byte(content)) {
+			return nil, fmt.Errorf("invalid create event content for %s", sender)
+		}
+		tpls[i] = NewCreateTemplate(sender, ts, json.RawMessage(content))
+	}
+	return tpls, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+var normalizeJSONTests = []struct {
+	name  string
+	input string
+	// The expected value of content.name after normalizing, or empty if normalizing should fail.
+	expected string
+}{
+	{"ASCII", `{"name":"meow"}`, "meow"},
+	{"RawEmoji", `{"name":"🐈‍⬛ cat"}`, "🐈‍⬛ cat"},
+	{"RawCJK", `{"name":"猫の部屋"}`, "猫の部屋"},
+	{"EscapedCJK", `{"name":"\u732b\u306e\u90e8\u5c4b"}`, "猫の部屋"},
+	{"EscapedLatin1", `{"name":"caf\u00e9"}`, "café"},
+	{"UppercaseHex", `{"name":"caf\u00E9"}`, "café"},
+	{"SurrogatePair", `{"name":"\ud83d\udc08"}`, "🐈"},
+	{"UppercaseSurrogatePair", `{"name":"\uD83D\uDC08"}`, "🐈"},
+	{"SurrogatePairBetweenRaw", `{"name":"猫\ud83d\udc08猫"}`, "猫🐈猫"},
+	{"ControlCharacter", `{"name":"a\u0001b"}`, "a\x01b"},
+	{"EscapedBackslashBeforeU", `{"name":"\\u0041"}`, `\u0041`},
+	{"EscapedBackslashBeforeSurrogate", `{"name":"\\ud83d"}`, `\ud83d`},
+	{"EscapedQuoteAndBackslash", `{"name":"\"\\\u0041"}`, `"\A`},
+	{"UnpairedHighSurrogate", `{"name":"\ud83d"}`, ""},
+	{"UnpairedHighSurrogateAtEnd", `{"name":"x\ud83d"}`, ""},
+	{"UnpairedLowSurrogate", `{"name":"\udc08"}`, ""},
+	{"ReversedSurrogatePair", `{"name":"\udc08\ud83d"}`, ""},
+	{"HighSurrogateBeforeBMP", `{"name":"\ud83d\u0041"}`, ""},
+	{"HighSurrogateBeforeRaw", `{"name":"\ud83dA"}`, ""},
+	{"TwoHighSurrogates", `{"name":"\ud83d\ud83d\udc08"}`, ""},
+	{"RealBackslashThenUnpaired", `{"name":"\\\ud83d"}`, ""},
+	{"InvalidUTF8", "{\"name\":\"\xff\"}", ""},
+}
+
+func TestNormalizeJSON(t *testing.T) {
+	for _, test := range normalizeJSONTests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := normalizeJSON([]byte(test.input))
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", normalized)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gjson.GetBytes(normalized, "name").Str; got != test.expected {
+				t.Errorf("expected name %q, got %q", test.expected, got)
+			}
+			if again, err := normalizeJSON(normalized); err != nil || string(again) != string(normalized) {
+				t.Errorf("normalizing isn't idempotent: %s -> %s (%v)", normalized, again, err)
+			}
+		})
+	}
+}
+
+func TestParseHexEscape(t *testing.T) {
+	tests := map[string]rune{
+		"0000": 0,
+		"0041": 'A',
+		"00e9": 'é',
+		"00E9": 'é',
+		"732b": '猫',
+		"d83d": 0xd83d,
+		"DC08": 0xdc08,
+		"ffff": 0xffff,
+	}
+	for hex, expected := range tests {
+		if got := parseHexEscape([]byte(hex)); got != expected {
+			t.Errorf("parseHexEscape(%q) = %U, expected %U", hex, got, expected)
+		}
+	}
+}
+
+// TestNormalizedContentEventID mines an event for each normalized content and checks that the event ID the miner
+// reports is the same one that a second implementation of canonical JSON and hashing computes.
+func TestNormalizedContentEventID(t *testing.T) {
+	for _, test := range normalizeJSONTests {
+		if test.expected == "" {
+			continue
+		}
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			content, err := normalizeJSON([]byte(`{"room_version":"12",` + test.input[1:]))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tpl := NewCreateTemplate("@meow:example.com", 1735689600000, json.RawMessage(content))
+			found := mineTemplate(tpl, CompileMatcher(PrefixMatcher("A")), 1, time.After(10*time.Second))
+			if len(found) == 0 {
+				t.Fatal("didn't find an event")
+			}
+			c := found[0]
+			if err := auditEventID(c.PDU, c.EventID); err != nil {
+				t.Error(err)
+			}
+			if breakdown := NewHashBreakdown(c.PDU); breakdown.ReferenceHash != c.EventID {
+				t.Errorf("hash breakdown computed event ID %s, miner found %s", breakdown.ReferenceHash, c.EventID)
+			}
+			if got := gjson.GetBytes(c.PDU, "content.name").Str; got != test.expected {
+				t.Errorf("expected name %q in the mined event, got %q", test.expected, got)
+			}
+		})
+	}
+}
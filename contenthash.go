@@ -0,0 +1,34 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The longest content hash prefix that fits in the raw compare, as each character encodes 6 bits.
+const maxContentHashPrefixLength = fastCompareLength * 8 / 6
+
+// setContentHashPrefix makes the matcher also require the content hash (hashes.sha256) to start with the given prefix.
+// The content hash is always encoded with standard base64, so unlike event IDs, it can contain + and / but not - and _.
+func (cm *CompiledMatcher) setContentHashPrefix(prefix string) error {
+	if len(prefix) > maxContentHashPrefixLength {
+		return fmt.Errorf("prefix too long, must be at most %d characters", maxContentHashPrefixLength)
+	}
+	for i := 0; i < len(prefix); i++ {
+		if strings.IndexByte(base64StdAlphabet, prefix[i]) == -1 {
+			return fmt.Errorf("%q can't appear in content hashes, which use standard base64 (A-Z, a-z, 0-9, + and /)", prefix[i])
+		}
+	}
+	content := newRawCompare([]byte(prefix), bytes.Repeat([]byte{0xff}, len(prefix)), base64StdAlphabet)
+	cm.content = &content
+	cm.contentProbability = math.Pow(64, -float64(len(prefix)))
+	return nil
+}
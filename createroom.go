@@ -0,0 +1,67 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+// newMatrixClient creates a client for the homeserver given with --homeserver using the access token in MATRIX_ACCESS_TOKEN.
+func newMatrixClient() (*mautrix.Client, error) {
+	if *homeserverURL == "" {
+		return nil, fmt.Errorf("--homeserver is not set")
+	}
+	token := os.Getenv("MATRIX_ACCESS_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("MATRIX_ACCESS_TOKEN is not set")
+	}
+	cli, err := mautrix.NewClient(*homeserverURL, "", token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid homeserver URL: %w", err)
+	}
+	return cli, nil
+}
+
+// checkCreateRoomFlags checks that the room can be created if --create-room is used, so that it isn't only
+// found out after mining. The access token is used by the sender, so there can only be one.
+func checkCreateRoomFlags() error {
+	if !*createRoom {
+		return nil
+	} else if len(senders()) > 1 {
+		return fmt.Errorf("--create-room only supports a single user ID")
+	} else if *homeserverURL == "" {
+		return fmt.Errorf("--create-room requires --homeserver")
+	}
+	_, err := newMatrixClient()
+	return err
+}
+
+// createRoomOnServer sends the given /createRoom request for the candidate to the homeserver. Homeservers that
+// don't support predetermined room IDs will create a room with a different ID, so that's treated as an error.
+func createRoomOnServer(c *Candidate, req map[string]any) error {
+	cli, err := newMatrixClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	var resp mautrix.RespCreateRoom
+	_, err = cli.MakeRequest(ctx, http.MethodPost, cli.BuildClientURL("v3", "createRoom"), req, &resp)
+	if err != nil {
+		return err
+	} else if resp.RoomID != c.RoomID() {
+		return fmt.Errorf("the homeserver created %s instead of %s, so it probably doesn't support predetermined room IDs", resp.RoomID, c.RoomID())
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Created room", resp.RoomID)
+	return nil
+}
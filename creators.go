@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// applyAdditionalCreators adds the user IDs given with --additional-creator to the additional_creators list
+// of the given create content. Any creators already in the content are kept, and the list is sorted and deduplicated
+// so that the same set of creators always results in the same create event.
+func applyAdditionalCreators(createContent string) (string, error) {
+	var creators []string
+	for _, arg := range *additionalCreators {
+		for _, userID := range strings.Split(arg, ",") {
+			if userID = strings.TrimSpace(userID); userID != "" {
+				creators = append(creators, userID)
+			}
+		}
+	}
+	if len(creators) == 0 {
+		return createContent, nil
+	} else if !gjson.Valid(createContent) {
+		return "", fmt.Errorf("invalid create event content")
+	}
+	content := gjson.Parse(createContent)
+	roomVersion := content.Get("room_version").Str
+	if info, _ := getRoomVersion(roomVersion); !info.AdditionalCreators {
+		return "", fmt.Errorf("room version %q doesn't support additional creators", roomVersion)
+	}
+	if existing := content.Get("additional_creators"); existing.Exists() {
+		if !existing.IsArray() {
+			return "", fmt.Errorf("additional_creators in the create content must be a list")
+		}
+		for _, userID := range existing.Array() {
+			if userID.Type != gjson.String {
+				return "", fmt.Errorf("additional_creators in the create content must only contain user IDs")
+			}
+			creators = append(creators, userID.Str)
+		}
+	}
+	for _, userID := range creators {
+		if _, _, err := id.UserID(userID).Parse(); err != nil {
+			return "", fmt.Errorf("invalid additional creator %s", userID)
+		} else if slices.Contains(senders(), id.UserID(userID)) {
+			return "", fmt.Errorf("additional creator %s is already the creator of the room", userID)
+		}
+	}
+	slices.Sort(creators)
+	creators = slices.Compact(creators)
+	updated, err := sjson.Set(createContent, "additional_creators", creators)
+	if err != nil {
+		return "", fmt.Errorf("failed to add additional creators to create content: %w", err)
+	}
+	return updated, nil
+}
+
+// senders returns the user IDs given with -u, splitting comma-separated lists.
+func senders() (userIDs []id.UserID) {
+	for _, arg := range *creatorArgs {
+		for _, userID := range strings.Split(arg, ",") {
+			if userID = strings.TrimSpace(userID); userID != "" && !slices.Contains(userIDs, id.UserID(userID)) {
+				userIDs = append(userIDs, id.UserID(userID))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed upgrades/*.sql
+var upgradesFS embed.FS
+
+var databaseUpgrades dbutil.UpgradeTable
+
+func init() {
+	databaseUpgrades.RegisterFSPath(upgradesFS, "upgrades")
+}
+
+var database *dbutil.Database
+
+// The row in the runs table for the current run, or zero if there isn't one.
+var databaseRunID int64
+
+// openDatabase opens the database given with --database and upgrades its schema if necessary.
+// The pure-Go SQLite driver is used, so that release binaries can be built without cgo.
+func openDatabase() error {
+	if *databasePath == "" || database != nil {
+		return nil
+	}
+	db, err := dbutil.NewWithDialect("file:"+*databasePath+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", "sqlite")
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	db.Owner = "matrix-rig"
+	db.UpgradeTable = databaseUpgrades
+	if err = db.Upgrade(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade database: %w", err)
+	}
+	database = db
+	return nil
+}
+
+// startRunRecord adds the current run to the runs table. Its outcome and hash count are filled in by finishRunRecord.
+func startRunRecord(start time.Time) {
+	if database == nil {
+		return
+	}
+	hardware := getHardwareInfo()
+	res, err := database.Exec(context.Background(),
+		"INSERT INTO runs (started_at, hostname, cpu_model, threads, sender, prefix, timestamp, content) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		start.UnixMilli(), hardware.Hostname, hardware.CPUModel, *threadCount, *creator, *prefix, *timestamp, *createContent,
+	)
+	if err == nil {
+		databaseRunID, err = res.LastInsertId()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to record run in database:", err)
+	}
+}
+
+// finishRunRecord stores the outcome, duration and hash count of the current run.
+func finishRunRecord(outcome string, start time.Time, hashes uint64) {
+	if database == nil || databaseRunID == 0 {
+		return
+	}
+	_, err := database.Exec(context.Background(),
+		"UPDATE runs SET finished_at=$1, outcome=$2, hashes=$3, hashrate=$4 WHERE id=$5",
+		time.Now().UnixMilli(), outcome, hashes, float64(hashes)/time.Since(start).Seconds(), databaseRunID,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to record run outcome in database:", err)
+	}
+}
+
+// storeDatabaseResult adds the result to the results table. Results that are already in the database are ignored.
+func storeDatabaseResult(c *Candidate) {
+	if database == nil {
+		return
+	}
+	var runID sql.NullInt64
+	if databaseRunID != 0 && !c.Cached {
+		runID = sql.NullInt64{Int64: databaseRunID, Valid: true}
+	}
+	var matchedLength sql.NullInt64
+	if c.MatchedLength > 0 {
+		matchedLength = sql.NullInt64{Int64: int64(c.MatchedLength), Valid: true}
+	}
+	_, err := database.Exec(context.Background(),
+		"INSERT INTO results (room_id, run_id, spec_hash, sender, prefix, pdu, matched_length, found_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (room_id) DO NOTHING",
+		c.RoomID(), runID, cacheSpecHash(senders(), json.RawMessage(*createContent)), c.Sender(), *prefix, string(c.PDU), matchedLength, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to store result in database:", err)
+	}
+}
+
+// loadDatabaseResult returns an earlier result from the database for the same parameters, or nil if there isn't one.
+func loadDatabaseResult(specHash string, matcher *CompiledMatcher) *Candidate {
+	if database == nil {
+		return nil
+	}
+	rows, err := database.Query(context.Background(), "SELECT pdu FROM results WHERE spec_hash=$1 ORDER BY found_at", specHash)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to query earlier results from database:", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pdu string
+		if err = rows.Scan(&pdu); err != nil {
+			break
+		}
+		breakdown := NewHashBreakdown([]byte(pdu))
+		contentHash, _ := base64.RawStdEncoding.DecodeString(breakdown.ContentHash)
+		// Best-effort results are stored too, so the result may not actually match.
+		if matcher.Match([]byte(breakdown.ReferenceHash)) && matcher.MatchContentHash(contentHash) {
+			_, _ = fmt.Fprintln(os.Stderr, "Reusing earlier result from database")
+			return &Candidate{EventID: breakdown.ReferenceHash, PDU: []byte(pdu), Cached: true}
+		}
+	}
+	if err = errors.Join(err, rows.Err()); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to read earlier results from database:", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/federation"
+)
+
+// printDryRun prints the templates that would be mined and the size of the search, for --dry-run.
+func printDryRun(tpls []*Template, matcher Matcher, signingKey *federation.SigningKey) {
+	encodedLength := base64.RawURLEncoding.EncodedLen(tpls[0].RandomnessLength)
+	for _, tpl := range tpls {
+		fmt.Println("Template for", gjson.GetBytes(tpl.PDU, "sender").Str)
+		fmt.Println(" ", string(tpl.PDU))
+		if tpl.RandomnessLength > 0 {
+			fmt.Printf("  Randomness (content.%s): %d bytes, %d characters at offset %d", strings.Join(randomnessPath(), "."), tpl.RandomnessLength, encodedLength, tpl.RandomOffset)
+			if tpl.RandomOffsetWithHash >= 0 {
+				fmt.Printf(" (offset %d in the redacted event)\n", tpl.RandomOffsetWithHash)
+			} else {
+				fmt.Println(" (removed by redaction)")
+			}
+		} else {
+			fmt.Println("  No randomness")
+		}
+		fmt.Printf("  Content hash at offset %d in the redacted event\n", tpl.HashOffset)
+		fmt.Printf("  Timestamp at offset %d (offset %d in the redacted event), %d timestamps from %d to %d\n", tpl.TimestampOffset, tpl.TimestampOffsetWithHash, tpl.TimestampCount(), tpl.MinTimestamp, tpl.MaxTimestamp)
+		fmt.Printf("  Event size: %d/%d bytes\n", eventSize(tpl, signingKey), maxPDUSize)
+		if tpl.Redacted {
+			fmt.Println("  Redacted event used for the reference hash:")
+			fmt.Println(" ", string(tpl.PDUWithHash))
+		}
+	}
+	// Without randomness, the threads share the timestamps of each sender, otherwise each thread has its own counter.
+	events := float64(tpls[0].TimestampCount() * int64(len(tpls)))
+	if tpls[0].RandomnessLength > 0 {
+		perTimestamp := float64(tpls[0].counterEnd())
+		if perTimestamp == 0 {
+			perTimestamp = math.Exp2(64)
+		}
+		events = perTimestamp * float64(tpls[0].TimestampCount()) * float64(*threadCount)
+	}
+	p := matcher.Probability()
+	if p == 0 {
+		fmt.Printf("Keyspace: %.4g events\n", events)
+		fmt.Println("Difficulty: nothing to match with --score, the best-scoring room ID within the time limit is output")
+		return
+	}
+	chance := -math.Expm1(events * math.Log1p(-p))
+	fmt.Printf("Keyspace: %.4g events, which have a %.3g%% chance of containing a match\n", events, chance*100)
+	fmt.Printf("Difficulty: match chance %.3g per hash, expected %.4g hashes", p, 1/p)
+	if *hashrate > 0 {
+		fmt.Printf(" (%s at %.0f hashes/s)", formatSeconds(1/p / *hashrate), *hashrate)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const joulesPerKWh = 3.6e6
+
+// How often RAPL counters are sampled. The counters wrap around after a few hundred kilojoules,
+// so they need to be read often enough that they never wrap more than once between samples.
+const raplSampleInterval = 10 * time.Second
+
+// raplDomain is a top-level Intel RAPL power domain (i.e. a CPU package) exposed through the powercap interface.
+type raplDomain struct {
+	path     string
+	maxRange uint64
+	last     uint64
+}
+
+func readUintFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func findRAPLDomains() []*raplDomain {
+	paths, _ := filepath.Glob("/sys/class/powercap/intel-rapl:*")
+	var domains []*raplDomain
+	for _, path := range paths {
+		// Subdomains like intel-rapl:0:0 are included in their package, so skip them to avoid counting twice.
+		if strings.Count(filepath.Base(path), ":") != 1 {
+			continue
+		}
+		energy, err := readUintFile(filepath.Join(path, "energy_uj"))
+		if err != nil {
+			continue
+		}
+		maxRange, err := readUintFile(filepath.Join(path, "max_energy_range_uj"))
+		if err != nil {
+			continue
+		}
+		domains = append(domains, &raplDomain{path: filepath.Join(path, "energy_uj"), maxRange: maxRange, last: energy})
+	}
+	return domains
+}
+
+// energyMeter measures the energy used since it was started, either by reading RAPL counters or by multiplying a fixed power draw by time.
+type energyMeter struct {
+	start   time.Time
+	watts   float64
+	domains []*raplDomain
+	lock    sync.Mutex
+	totalUJ uint64
+}
+
+// startEnergyMeter starts measuring energy use. If --watts is set, it'll be used as the power draw,
+// otherwise RAPL is used if available. Returns nil if there's no way to estimate energy use.
+func startEnergyMeter() *energyMeter {
+	em := &energyMeter{start: time.Now(), watts: *watts}
+	if em.watts > 0 {
+		return em
+	}
+	em.domains = findRAPLDomains()
+	if len(em.domains) == 0 {
+		return nil
+	}
+	go func() {
+		for {
+			time.Sleep(raplSampleInterval)
+			em.sample()
+		}
+	}()
+	return em
+}
+
+func (em *energyMeter) sample() {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+	for _, d := range em.domains {
+		energy, err := readUintFile(d.path)
+		if err != nil {
+			continue
+		}
+		if energy >= d.last {
+			em.totalUJ += energy - d.last
+		} else {
+			em.totalUJ += d.maxRange - d.last + energy
+		}
+		d.last = energy
+	}
+}
+
+func (em *energyMeter) Source() string {
+	if em.watts > 0 {
+		return fmt.Sprintf("%g W estimate", em.watts)
+	}
+	return "RAPL"
+}
+
+// Joules returns the energy used since the meter was started.
+func (em *energyMeter) Joules() float64 {
+	if em.watts > 0 {
+		return em.watts * time.Since(em.start).Seconds()
+	}
+	em.sample()
+	em.lock.Lock()
+	defer em.lock.Unlock()
+	return float64(em.totalUJ) / 1e6
+}
+
+// EnergyReport describes the energy used by a run and the expected energy needed to find a solution.
+type EnergyReport struct {
+	Source         string  `json:"source"`
+	Joules         float64 `json:"joules"`
+	JoulesPerHash  float64 `json:"joules_per_hash"`
+	ExpectedJoules float64 `json:"expected_joules_per_solution,omitempty"`
+	PricePerKWh    float64 `json:"price_per_kwh,omitempty"`
+	Cost           float64 `json:"cost,omitempty"`
+	ExpectedCost   float64 `json:"expected_cost_per_solution,omitempty"`
+}
+
+// Report returns an energy report for the given number of checked hashes and per-hash match probability.
+func (em *energyMeter) Report(hashes uint64, p float64) *EnergyReport {
+	if em == nil || hashes == 0 {
+		return nil
+	}
+	er := &EnergyReport{Source: em.Source(), Joules: em.Joules()}
+	er.JoulesPerHash = er.Joules / float64(hashes)
+	// Without anything to match (with --score), there's no expected energy per solution.
+	if p > 0 {
+		er.ExpectedJoules = er.JoulesPerHash / p
+	}
+	er.setPrice(*pricePerKWh)
+	return er
+}
+
+func (er *EnergyReport) setPrice(price float64) {
+	if price > 0 {
+		er.PricePerKWh = price
+		er.Cost = er.Joules / joulesPerKWh * price
+		er.ExpectedCost = er.ExpectedJoules / joulesPerKWh * price
+	}
+}
+
+func formatEnergy(joules float64) string {
+	return fmt.Sprintf("%.4g J (%.4g kWh)", joules, joules/joulesPerKWh)
+}
+
+// Print writes the energy report to stderr.
+func (er *EnergyReport) Print() {
+	if er == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Energy used: %s, %.3g J per hash (%s)\n", formatEnergy(er.Joules), er.JoulesPerHash, er.Source)
+	if er.ExpectedJoules > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Expected energy per solution: %s\n", formatEnergy(er.ExpectedJoules))
+	}
+	if er.PricePerKWh > 0 && er.ExpectedJoules > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Cost: %.4g, expected cost per solution: %.4g (at %g per kWh)\n", er.Cost, er.ExpectedCost, er.PricePerKWh)
+	} else if er.PricePerKWh > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Cost: %.4g (at %g per kWh)\n", er.Cost, er.PricePerKWh)
+	}
+}
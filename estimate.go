@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// cloudProfile is a rough hashrate and on-demand price for a cloud instance type.
+// The hashrates are ballpark figures for matrix-rig on all cores of the instance, not exact benchmarks.
+// There are no GPU instances, as matrix-rig only hashes on the CPU.
+type cloudProfile struct {
+	Provider     string
+	Instance     string
+	Kind         string
+	Hashrate     float64
+	PricePerHour float64
+}
+
+var cloudProfiles = []cloudProfile{
+	{"AWS", "c7i.4xlarge", "16 vCPU", 20e6, 0.714},
+	{"AWS", "c7a.16xlarge", "64 vCPU", 110e6, 3.28},
+	{"AWS", "c7g.16xlarge", "64 vCPU", 150e6, 2.32},
+	{"GCP", "c3-highcpu-22", "22 vCPU", 28e6, 0.95},
+	{"GCP", "c2d-highcpu-56", "56 vCPU", 80e6, 2.03},
+	{"Azure", "F16s_v2", "16 vCPU", 18e6, 0.677},
+	{"Hetzner", "CCX63", "48 vCPU", 60e6, 0.64},
+}
+
+// The percentile of searches that finish within the "likely" time and cost columns.
+const estimateLikelyPercentile = 90
+
+func runEstimate() {
+	if !*estimateCloud && *hashrate <= 0 {
+		fatalf(ExitInvalidInput, "Either --hashrate or --cloud must be specified")
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	p := matcher.Probability()
+	if p == 0 {
+		fatalf(ExitUsage, "--score searches until the time limit, so there's nothing to estimate")
+	}
+	expected := 1 / p
+	// The number of attempts is geometrically distributed, so the percentile can be computed directly.
+	likely := max(1, math.Log(1-estimateLikelyPercentile/100.0)/math.Log1p(-p))
+	fmt.Printf("Match chance %.3g per hash, expected %.4g hashes (%.4g hashes for %d%% of searches)\n", p, expected, likely, estimateLikelyPercentile)
+	if *hashrate > 0 {
+		fmt.Printf("At %.0f hashes/s: expected %s, %d%% within %s\n", *hashrate, formatSeconds(expected / *hashrate), estimateLikelyPercentile, formatSeconds(likely / *hashrate))
+	}
+	if !*estimateCloud {
+		return
+	}
+	fmt.Printf("\n%-8s  %-15s  %-11s  %10s  %7s  %-16s  %10s  %-16s  %10s\n", "Provider", "Instance", "Hardware", "Hashes/s", "$/hour", "Expected time", "Cost", fmt.Sprintf("%d%% time", estimateLikelyPercentile), "Cost")
+	for _, cp := range cloudProfiles {
+		expectedSec := expected / cp.Hashrate
+		likelySec := likely / cp.Hashrate
+		fmt.Printf(
+			"%-8s  %-15s  %-11s  %10.3g  %7.3f  %-16s  %10s  %-16s  %10s\n",
+			cp.Provider, cp.Instance, cp.Kind, cp.Hashrate, cp.PricePerHour,
+			formatSeconds(expectedSec), formatDollars(expectedSec/3600*cp.PricePerHour),
+			formatSeconds(likelySec), formatDollars(likelySec/3600*cp.PricePerHour),
+		)
+	}
+	fmt.Println("\nHashrates and on-demand prices are approximate.")
+}
+
+func formatDollars(amount float64) string {
+	if amount < 1e6 {
+		return fmt.Sprintf("$%.2f", amount)
+	}
+	return fmt.Sprintf("$%.3g", amount)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// The encoding used to turn reference hashes into event IDs. Room versions 4 and up use unpadded base64url,
+// but experimental room versions may use something else, so the encoding can be overridden with flags.
+var eventIDEncoding = base64.RawURLEncoding
+var eventIDAlphabet = base64URLAlphabet
+
+// eventIDLength returns the length of encoded event IDs, without the sigil.
+func eventIDLength() int {
+	return eventIDEncoding.EncodedLen(sha256.Size)
+}
+
+func setupEventIDEncoding() error {
+	if len(*idAlphabet) != 64 {
+		return fmt.Errorf("event ID alphabet must be exactly 64 characters long")
+	}
+	for i := 0; i < len(*idAlphabet); i++ {
+		char := (*idAlphabet)[i]
+		if char == '\n' || char == '\r' || char == '=' || char >= 0x80 {
+			return fmt.Errorf("event ID alphabet must only contain ASCII characters and not %q", char)
+		} else if strings.IndexByte((*idAlphabet)[i+1:], char) != -1 {
+			return fmt.Errorf("event ID alphabet contains %q more than once", char)
+		}
+	}
+	if *idAlphabet == base64URLAlphabet && !*idPadding {
+		return nil
+	}
+	eventIDAlphabet = *idAlphabet
+	eventIDEncoding = base64.NewEncoding(eventIDAlphabet)
+	if !*idPadding {
+		eventIDEncoding = eventIDEncoding.WithPadding(base64.NoPadding)
+	}
+	return nil
+}
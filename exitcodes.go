@@ -0,0 +1,66 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes used by all commands.
+const (
+	// A result was found (or the command otherwise succeeded).
+	ExitFound = 0
+	// The search ended without finding a result, e.g. because the time limit was reached.
+	ExitNotFound = 1
+	// Something outside the search itself failed, like reading or writing a file.
+	ExitBackendFailure = 2
+	// The command line couldn't be parsed, or help was requested.
+	ExitUsage = 3
+	// The flags were parsed, but their values are invalid.
+	ExitInvalidInput = 4
+	// The search was interrupted with SIGINT or SIGTERM.
+	ExitInterrupted = 130
+)
+
+var exitCodeNames = map[int]string{
+	ExitNotFound:       "not_found",
+	ExitBackendFailure: "backend_failure",
+	ExitUsage:          "usage",
+	ExitInvalidInput:   "invalid_input",
+	ExitInterrupted:    "interrupted",
+}
+
+// ErrorObject is the machine-readable error written to stderr in --json mode.
+type ErrorObject struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// exitWithError writes the message to stderr (as an ErrorObject in --json mode) and exits with the given code.
+func exitWithError(code int, msg string) {
+	if *jsonErrors {
+		data, _ := json.Marshal(&ErrorObject{Error: exitCodeNames[code], Message: msg, ExitCode: code})
+		_, _ = fmt.Fprintln(os.Stderr, string(data))
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, msg)
+	}
+	os.Exit(code)
+}
+
+// fatalf formats the error message like fmt.Printf and exits with the given code.
+func fatalf(code int, format string, args ...any) {
+	exitWithError(code, fmt.Sprintf(format, args...))
+}
+
+// fatal formats the error message like fmt.Println and exits with the given code.
+func fatal(code int, args ...any) {
+	exitWithError(code, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
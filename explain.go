@@ -0,0 +1,55 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+)
+
+// runExplain prints each step of deriving the room ID from a create event, for debugging discrepancies with homeservers.
+func runExplain() {
+	pdu, _, err := readVerifyInput(flag.Arg(1))
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	step := 0
+	printStep := func(title, value string) {
+		step++
+		fmt.Printf("%d. %s\n   %s\n\n", step, title, value)
+	}
+	existingHash := gjson.GetBytes(pdu, "hashes.sha256")
+	stripped := exerrors.Must(sjson.DeleteBytes(exerrors.Must(sjson.DeleteBytes(pdu, "signatures")), "unsigned"))
+	printStep("Canonical JSON of the event, without signatures and unsigned, which aren't covered by either hash", string(canonicaljson.CanonicalJSONAssumeValid(stripped)))
+	breakdown := NewHashBreakdown(pdu)
+	printStep("Content hash input: the event without the hashes field", breakdown.ContentHashInput)
+	contentHash := sha256.Sum256([]byte(breakdown.ContentHashInput))
+	printStep("SHA-256 of the content hash input", hex.EncodeToString(contentHash[:]))
+	printStep("Content hash: the SHA-256 in unpadded standard base64", breakdown.ContentHash)
+	switch {
+	case !existingHash.Exists():
+		_, _ = fmt.Fprintln(os.Stderr, "Warning: the event doesn't have a content hash, using the recomputed one")
+		pdu = exerrors.Must(sjson.SetBytes(pdu, "hashes", map[string]string{"sha256": breakdown.ContentHash}))
+		breakdown = NewHashBreakdown(pdu)
+	case existingHash.Str != breakdown.ContentHash:
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: the event has the content hash %s, so it won't be accepted by homeservers\n", existingHash.Str)
+	}
+	printStep(fmt.Sprintf("Redacted form with the content hash, using the redaction rules of room version %s", gjson.GetBytes(pdu, "content.room_version").Str), breakdown.ReferenceHashInput)
+	referenceHash := sha256.Sum256([]byte(breakdown.ReferenceHashInput))
+	printStep("Reference hash: SHA-256 of the redacted form", hex.EncodeToString(referenceHash[:]))
+	printStep("Reference hash in unpadded base64url, which is the event ID without the sigil", breakdown.ReferenceHash)
+	printStep("Room ID: the sigil followed by the encoded reference hash", *roomIDSigil+breakdown.ReferenceHash)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter"
+)
+
+// ExprMatcher accepts event IDs for which a CEL expression evaluates to true. The event ID is available
+// as the string variable id, and the string extension functions like lowerAscii and substring are enabled.
+// Evaluation errors, like an out of range substring, count as not matching.
+type ExprMatcher struct {
+	program cel.Program
+	// The literal prefix required by a top-level id.startsWith, used as the prefilter.
+	literal []byte
+	prob    float64
+}
+
+var _ LiteralPrefixer = (*ExprMatcher)(nil)
+
+// NewExprMatcher parses and compiles the given expression. The expression must evaluate to a bool.
+func NewExprMatcher(expr string) (*ExprMatcher, error) {
+	env, err := cel.NewEnv(cel.Variable("id", cel.StringType), ext.Strings())
+	if err != nil {
+		return nil, err
+	}
+	compiled, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	} else if !compiled.OutputType().IsExactType(cel.BoolType) {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", compiled.OutputType())
+	}
+	program, err := env.Program(compiled, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, err
+	}
+	em := &ExprMatcher{program: program, literal: []byte(requiredPrefix(compiled.NativeRep().Expr()))}
+	em.prob = sampleProbability(em, em.literal)
+	return em, nil
+}
+
+// exprActivation provides the id variable to the program without allocating a map for every evaluation.
+type exprActivation string
+
+func (a exprActivation) ResolveName(name string) (any, bool) {
+	if name == "id" {
+		return string(a), true
+	}
+	return nil, false
+}
+
+func (a exprActivation) Parent() interpreter.Activation {
+	return nil
+}
+
+func (em *ExprMatcher) Match(eventID []byte) bool {
+	out, _, err := em.program.Eval(exprActivation(eventID))
+	return err == nil && out == types.True
+}
+
+// Probability returns an estimate of the match probability based on random sampling.
+func (em *ExprMatcher) Probability() float64 {
+	return em.prob
+}
+
+func (em *ExprMatcher) LiteralPrefix() []byte {
+	return em.literal
+}
+
+// requiredPrefix returns a literal that every event ID accepted by the expression must start with.
+func requiredPrefix(expr ast.Expr) string {
+	if expr.Kind() != ast.CallKind {
+		return ""
+	}
+	call := expr.AsCall()
+	switch call.FunctionName() {
+	case operators.LogicalAnd:
+		var longest string
+		for _, arg := range call.Args() {
+			if prefix := requiredPrefix(arg); len(prefix) > len(longest) {
+				longest = prefix
+			}
+		}
+		return longest
+	case "startsWith":
+		target, args := call.Target(), call.Args()
+		if !call.IsMemberFunction() || target.Kind() != ast.IdentKind || target.AsIdent() != "id" ||
+			len(args) != 1 || args[0].Kind() != ast.LiteralKind {
+			return ""
+		}
+		if literal, ok := args[0].AsLiteral().(types.String); ok {
+			return string(literal)
+		}
+	}
+	return ""
+}
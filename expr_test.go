@@ -0,0 +1,62 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestExprMatcher(t *testing.T) {
+	tests := []struct {
+		expr    string
+		prefix  string
+		matches []string
+		rejects []string
+	}{
+		{`id.startsWith("Cat") && !id.contains("_")`, "Cat", []string{"Catx"}, []string{"Cat_", "Dog"}},
+		{`id.indexOf("x") == -1`, "", []string{"abc"}, []string{"axc"}},
+		{`id.substring(0, 3) in ["abc", "def"]`, "", []string{"abcd", "defg"}, []string{"ghij"}},
+		{`id.size() > 3 ? id.endsWith("z") : id == "ab"`, "", []string{"abcz", "ab"}, []string{"abcd", "abc"}},
+		{`int(id.substring(0, 2)) < 20`, "", []string{"19ab"}, []string{"20ab", "ab"}},
+		{`string(id.size()) == "4"`, "", []string{"abcd"}, []string{"abc"}},
+		{`id.lowerAscii().startsWith("cat")`, "", []string{"CaT1"}, []string{"Dog1"}},
+		{`id.matches("^[0-9]+$") && id.startsWith("1") && id.startsWith("12")`, "12", []string{"123"}, []string{"12a", "13"}},
+		// Out of range substrings are evaluation errors in CEL, which count as not matching.
+		{`id.substring(0, 10) == "abcdefghij"`, "", []string{"abcdefghijk"}, []string{"abc"}},
+		{`id.startsWith("A") || id.startsWith("B")`, "", []string{"A", "B"}, []string{"C"}},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			t.Parallel()
+			em, err := NewExprMatcher(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(em.LiteralPrefix()) != test.prefix {
+				t.Errorf("expected required prefix %q, got %q", test.prefix, em.LiteralPrefix())
+			}
+			for _, id := range test.matches {
+				if !em.Match([]byte(id)) {
+					t.Errorf("expected %s to match", id)
+				}
+			}
+			for _, id := range test.rejects {
+				if em.Match([]byte(id)) {
+					t.Errorf("expected %s not to match", id)
+				}
+			}
+		})
+	}
+}
+
+func TestExprMatcherErrors(t *testing.T) {
+	for _, expr := range []string{`id.size()`, `id.startsWith(`, `foo == "a"`, `id.startsWith(1)`} {
+		if _, err := NewExprMatcher(expr); err == nil {
+			t.Errorf("expected an error for %s", expr)
+		}
+	}
+}
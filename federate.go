@@ -0,0 +1,75 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+// federationTargets returns the server names given with --federate-to, splitting comma-separated lists.
+func federationTargets() (servers []string) {
+	for _, arg := range *federateTo {
+		for _, server := range strings.Split(arg, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				servers = append(servers, server)
+			}
+		}
+	}
+	return
+}
+
+// checkFederationFlags checks that the event can be sent over federation if --federate-to is used,
+// as the transaction and the event must be signed by the origin server.
+func checkFederationFlags(signingKey *federation.SigningKey) error {
+	if len(federationTargets()) > 0 && signingKey == nil {
+		return fmt.Errorf("--federate-to requires --signing-key")
+	}
+	return nil
+}
+
+// federatePDU sends a signed event to the servers given with --federate-to in a federation transaction
+// from the sender's server. Failures are logged, but don't affect the result, as the event is already output.
+func federatePDU(c *Candidate, key *federation.SigningKey) {
+	servers := federationTargets()
+	if len(servers) == 0 {
+		return
+	}
+	origin := c.Sender().Homeserver()
+	cli := federation.NewClient(origin, key, nil)
+	eventID := id.EventID("$" + c.EventID)
+	for _, server := range servers {
+		req := &federation.ReqSendTransaction{
+			Destination:    server,
+			TxnID:          base64.RawURLEncoding.EncodeToString(randomBytes(12)),
+			Origin:         origin,
+			OriginServerTS: jsontime.UM(time.Now()),
+			PDUs:           []federation.PDU{c.PDU},
+		}
+		var resp federation.RespSendTransaction
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := cli.MakeRequest(ctx, server, true, http.MethodPut, federation.URLPath{"v1", "send", req.TxnID}, req, &resp)
+		cancel()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to send", eventID, "to", server, "-", err)
+		} else if result, ok := resp.PDUs[eventID]; ok && result.Error != "" {
+			_, _ = fmt.Fprintln(os.Stderr, server, "rejected", eventID, "-", result.Error)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, "Sent", eventID, "to", server)
+		}
+	}
+}
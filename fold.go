@@ -0,0 +1,111 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"math"
+)
+
+// Clearing this bit turns lowercase ASCII letters into uppercase. For any other ASCII character,
+// the result is never an uppercase letter, so comparing the masked byte with an uppercase letter
+// matches exactly the two cases of that letter.
+const caseFoldMask = 0xdf
+
+func isASCIILetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// foldPattern returns the value and mask for matching the given string case-insensitively.
+func foldPattern(s string) (value, mask []byte) {
+	value = []byte(s)
+	mask = make([]byte, len(s))
+	for i, b := range value {
+		if isASCIILetter(b) {
+			value[i] = b & caseFoldMask
+			mask[i] = caseFoldMask
+		} else {
+			mask[i] = 0xff
+		}
+	}
+	return
+}
+
+// foldProbability returns the chance of a random event ID matching the masked pattern at a specific position.
+func foldProbability(mask []byte) float64 {
+	p := charProbability(len(mask))
+	for _, m := range mask {
+		if m == caseFoldMask {
+			p *= 2
+		}
+	}
+	return p
+}
+
+func matchMasked(eventID, value, mask []byte) bool {
+	if len(eventID) < len(value) {
+		return false
+	}
+	for i, v := range value {
+		if eventID[i]&mask[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// FoldPrefixMatcher accepts event IDs starting with the given prefix in any combination of cases.
+type FoldPrefixMatcher struct {
+	value []byte
+	mask  []byte
+}
+
+var _ MaskedPrefixer = FoldPrefixMatcher{}
+
+func NewFoldPrefixMatcher(prefix string) FoldPrefixMatcher {
+	value, mask := foldPattern(prefix)
+	return FoldPrefixMatcher{value: value, mask: mask}
+}
+
+func (fpm FoldPrefixMatcher) Match(eventID []byte) bool {
+	return matchMasked(eventID, fpm.value, fpm.mask)
+}
+
+func (fpm FoldPrefixMatcher) Probability() float64 {
+	return foldProbability(fpm.mask)
+}
+
+func (fpm FoldPrefixMatcher) MaskedPrefix() (value, mask []byte) {
+	return fpm.value, fpm.mask
+}
+
+// FoldContainsMatcher accepts event IDs that contain the given string anywhere in any combination of cases.
+type FoldContainsMatcher struct {
+	value []byte
+	mask  []byte
+}
+
+func NewFoldContainsMatcher(s string) *FoldContainsMatcher {
+	value, mask := foldPattern(s)
+	return &FoldContainsMatcher{value: value, mask: mask}
+}
+
+func (fcm *FoldContainsMatcher) Match(eventID []byte) bool {
+	for i := 0; i+len(fcm.value) <= len(eventID); i++ {
+		if matchMasked(eventID[i:], fcm.value, fcm.mask) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fcm *FoldContainsMatcher) Probability() float64 {
+	positions := eventIDLength() - len(fcm.value) + 1
+	if positions <= 0 {
+		return 0
+	}
+	return -math.Expm1(float64(positions) * math.Log1p(-foldProbability(fcm.mask)))
+}
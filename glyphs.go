@@ -0,0 +1,45 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+// homoglyphGroups lists base64url characters that look the same (or nearly so) in common fonts.
+var homoglyphGroups = []string{
+	"Il1",
+	"O0o",
+	"S5s",
+	"Z2z",
+	"B8",
+	"G6",
+	"Cc",
+	"Uu",
+	"Vv",
+	"Ww",
+	"Xx",
+}
+
+// homoglyphs maps each character to the group of characters it can be confused with.
+var homoglyphs = func() (table [256]string) {
+	for _, group := range homoglyphGroups {
+		for i := 0; i < len(group); i++ {
+			table[group[i]] = group
+		}
+	}
+	return
+}()
+
+// addHomoglyphs extends the class to also accept everything that looks like one of its members.
+func (cc *charClass) addHomoglyphs() {
+	orig := *cc
+	for c, ok := range orig {
+		if !ok {
+			continue
+		}
+		for _, alt := range []byte(homoglyphs[c]) {
+			cc[alt] = true
+		}
+	}
+}
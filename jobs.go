@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+// pipelineJob is a line of input for the jobs command. Fields that aren't set default to the command-line flags.
+type pipelineJob struct {
+	Prefix    string          `json:"prefix"`
+	Content   json.RawMessage `json:"content"`
+	Sender    id.UserID       `json:"sender"`
+	Timestamp int64           `json:"timestamp"`
+	// The time limit for the job in seconds.
+	Deadline *float64 `json:"deadline"`
+}
+
+// pipelineResult is a line of output from the jobs command, containing either the result or the error of a job.
+type pipelineResult struct {
+	// The index of the job in the input, starting from zero and counting empty lines too.
+	Job int `json:"job"`
+	*PorcelainResult
+	Error *ErrorObject `json:"error,omitempty"`
+}
+
+// The command-line flags that pipeline jobs can override.
+type pipelineDefaults struct {
+	prefixArgs    []string
+	sender        id.UserID
+	contentSource string
+	timestamp     int64
+}
+
+func newJobError(code int, err error) *ErrorObject {
+	return &ErrorObject{Error: exitCodeNames[code], Message: err.Error(), ExitCode: code}
+}
+
+// runPipelineJob applies the job's fields to the flags and mines a create event for it.
+func runPipelineJob(job *pipelineJob, defaults *pipelineDefaults, signingKey *federation.SigningKey) (*Candidate, *ErrorObject) {
+	*prefixArgs = defaults.prefixArgs
+	if job.Prefix != "" {
+		*prefixArgs = []string{job.Prefix}
+	}
+	*prefix = strings.Join(targetPrefixes(), ",")
+	sender := defaults.sender
+	if job.Sender != "" {
+		sender = job.Sender
+	}
+	*creator = sender.String()
+	*creatorArgs = []string{*creator}
+	*timestamp = defaults.timestamp
+	if job.Timestamp != 0 {
+		*timestamp = job.Timestamp
+	}
+	*createContent = defaults.contentSource
+	if job.Content != nil {
+		*createContent = string(job.Content)
+	}
+	var err error
+	if _, _, err = sender.Parse(); err != nil {
+		return nil, newJobError(ExitInvalidInput, fmt.Errorf("invalid user ID: %s", sender))
+	} else if err = parseContentTemplate(); err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	} else if *createContent, err = renderCreateContent(sender, *timestamp); err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	} else if !json.Valid([]byte(*createContent)) {
+		return nil, newJobError(ExitInvalidInput, fmt.Errorf("invalid create event content"))
+	} else if err = checkRoomVersion(*createContent); err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	} else if err = checkTemplateFlags(*timestamp, *createContent); err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	}
+	tpl := NewCreateTemplate(sender, *timestamp, json.RawMessage(*createContent))
+	if err = checkEventSize(tpl, signingKey); err != nil {
+		return nil, newJobError(ExitInvalidInput, err)
+	}
+	limit := float64(*maxSeconds)
+	if job.Deadline != nil {
+		limit = *job.Deadline
+	}
+	var deadline <-chan time.Time
+	if limit >= 0 {
+		deadline = time.After(time.Duration(limit * float64(time.Second)))
+	}
+	start := time.Now()
+	startRunRecord(start)
+	found, hashes := mineTemplateCounted(tpl, matcher, 1, deadline)
+	if len(found) == 0 {
+		finishRunRecord(OutcomeTimeout, start, hashes)
+		return nil, newJobError(ExitNotFound, fmt.Errorf("no matching create event found in the time limit"))
+	}
+	finishRunRecord(OutcomeFound, start, hashes)
+	c := found[0]
+	if signingKey != nil {
+		c.PDU = signPDU(c.PDU, sender.Homeserver(), signingKey)
+	}
+	return c, nil
+}
+
+// runJobs reads jobs from stdin as NDJSON and writes a pipelineResult for each one to stdout, one job at a time.
+func runJobs() {
+	signingKey, err := loadSelectedSigningKey()
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to load signing key:", err)
+	} else if err = openDatabase(); err != nil {
+		fatal(ExitBackendFailure, err)
+	}
+	defaults := &pipelineDefaults{
+		prefixArgs:    *prefixArgs,
+		sender:        id.UserID(*creator),
+		contentSource: createContentSource,
+		timestamp:     *timestamp,
+	}
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	// Jobs may contain create content up to the PDU size limit.
+	scanner.Buffer(nil, 4*maxPDUSize)
+	for i := 0; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		result := &pipelineResult{Job: i}
+		var job pipelineJob
+		if err = json.Unmarshal([]byte(line), &job); err != nil {
+			result.Error = newJobError(ExitInvalidInput, fmt.Errorf("invalid job: %w", err))
+		} else if c, jobErr := runPipelineJob(&job, defaults, signingKey); jobErr != nil {
+			result.Error = jobErr
+		} else if err = auditCandidate(c); err != nil {
+			result.Error = newJobError(ExitBackendFailure, fmt.Errorf("audit failed: %w", err))
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, "Job", i, "found", c.RoomID(), "after", c.Hashes, "hashes in", c.Duration.String())
+			result.PorcelainResult = NewPorcelainResult(c)
+			appendJournal(c)
+			storeDatabaseResult(c)
+			// The prefix and timestamp flags are set to the job's values at this point, so the object key uses them.
+			uploadArtifacts(c, "")
+		}
+		if result.Error != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Job", i, "failed:", result.Error.Message)
+		}
+		_ = out.Encode(result)
+	}
+	if err = scanner.Err(); err != nil {
+		fatal(ExitBackendFailure, "Failed to read jobs:", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+func runKeys() {
+	switch flag.Arg(1) {
+	case "generate":
+		key := federation.GenerateSigningKey()
+		if *keyVersion != "" {
+			if strings.ContainsAny(*keyVersion, " \n") {
+				fatalf(ExitInvalidInput, "Key version must not contain whitespace")
+			}
+			key.ID = id.NewKeyID(id.KeyAlgorithmEd25519, *keyVersion)
+		}
+		if *signingKeyPath == "" {
+			fmt.Println(key.SynapseString())
+			return
+		}
+		file, err := os.OpenFile(*signingKeyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			fatalf(ExitInvalidInput, "%s already exists, refusing to overwrite", *signingKeyPath)
+		} else if err != nil {
+			fatal(ExitBackendFailure, "Failed to create key file:", err)
+		}
+		_, err = fmt.Fprintln(file, key.SynapseString())
+		if err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			fatal(ExitBackendFailure, "Failed to write key file:", err)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, "Generated", key.ID, "with public key", key.Pub, "in", *signingKeyPath)
+	case "show":
+		path := *signingKeyPath
+		if flag.Arg(2) != "" {
+			path = flag.Arg(2)
+		}
+		if path == "" {
+			fatalf(ExitInvalidInput, "Specify the key file with --signing-key or as an argument")
+		}
+		keys, err := loadSigningKeys(path)
+		if err != nil {
+			fatal(ExitInvalidInput, "Failed to load signing keys:", err)
+		}
+		for i, key := range keys {
+			if i == 0 {
+				fmt.Println(key.ID, key.Pub, "(active)")
+			} else {
+				fmt.Println(key.ID, key.Pub)
+			}
+		}
+	default:
+		fatalf(ExitUsage, "Usage: matrix-rig keys <generate|show> [--signing-key=file] [--key-version=version]")
+	}
+}
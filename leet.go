@@ -0,0 +1,67 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The maximum number of leet-speak variants to generate for all prefixes combined.
+const maxLeetExpansions = 4096
+
+// Leet-speak substitutions for each character. The character itself is always included as well.
+var leetSubstitutions = map[byte]string{
+	'a': "4", 'A': "4",
+	'e': "3", 'E': "3",
+	'i': "1l", 'I': "1l",
+	'o': "0", 'O': "0",
+	's': "5", 'S': "5",
+	't': "7", 'T': "7",
+}
+
+// expandLeet returns all variants of the given prefix with leet-speak substitutions applied.
+// Character sets in wildcard patterns are kept as-is.
+func expandLeet(prefix string) []string {
+	variants := []string{""}
+	for i := 0; i < len(prefix); i++ {
+		options := []string{prefix[i : i+1]}
+		if prefix[i] == '[' {
+			if end := strings.IndexByte(prefix[i:], ']'); end > 0 {
+				options[0] = prefix[i : i+end+1]
+				i += end
+			}
+		} else {
+			for _, sub := range leetSubstitutions[prefix[i]] {
+				options = append(options, string(sub))
+			}
+		}
+		next := make([]string, 0, len(variants)*len(options))
+		for _, variant := range variants {
+			for _, option := range options {
+				next = append(next, variant+option)
+			}
+		}
+		variants = next
+		if len(variants) > maxLeetExpansions {
+			break
+		}
+	}
+	return variants
+}
+
+// expandLeetPrefixes expands all the given prefixes with expandLeet.
+func expandLeetPrefixes(prefixes []string) ([]string, error) {
+	var expanded []string
+	for _, p := range prefixes {
+		expanded = append(expanded, expandLeet(p)...)
+		if len(expanded) > maxLeetExpansions {
+			return nil, fmt.Errorf("too many leet-speak variants, at most %d are supported", maxLeetExpansions)
+		}
+	}
+	return expanded, nil
+}
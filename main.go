@@ -7,195 +7,525 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
 	"os"
+	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
-	"unsafe"
 
-	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
-	"go.mau.fi/util/exerrors"
-	"go.mau.fi/util/exgjson"
 	flag "maunium.net/go/mauflag"
 
-	"maunium.net/go/mautrix/crypto/canonicaljson"
 	"maunium.net/go/mautrix/id"
 )
 
-type CreatePDU struct {
-	AuthEvents     []string        `json:"auth_events"`
-	PrevEvents     []string        `json:"prev_events"`
-	Depth          int             `json:"depth"`
-	Hashes         *Hashes         `json:"hashes,omitempty"`
-	OriginServerTS int64           `json:"origin_server_ts"`
-	Sender         id.UserID       `json:"sender"`
-	StateKey       string          `json:"state_key"`
-	Type           string          `json:"type"`
-	Content        json.RawMessage `json:"content"`
-}
+var timestamp = flag.MakeFull("t", "timestamp", "Timestamp of the create event (defaults to current time)", strconv.FormatInt(time.Now().UnixMilli(), 10)).Int64()
+var randomnessFieldPath = flag.Make().LongKey("randomness-field").Usage("Key in the create event content to put the randomness in, nested keys are separated with slashes").Default(randomnessField).String()
+var noRandomness = flag.Make().LongKey("no-randomness").Usage("Don't add a randomness field at all and only vary the timestamp within --timestamp-window").Default("false").Bool()
+var randomnessLength = flag.Make().LongKey("randomness-length").Usage("Length of the randomness in bytes (4-16), each thread can check 256^(length-2) events per timestamp").Default(strconv.Itoa(defaultRandomnessLength)).Int()
+var timestampWindow = flag.Make().LongKey("timestamp-window").Usage("Vary the timestamp by up to this many seconds in either direction once a thread runs out of randomness").Default("0").Int64()
+var creatorArgs = flag.MakeFull("u", "user_id", "User ID of the room creator. Can be specified multiple times or as a comma-separated list to search over all of them at once.", "").StringArray()
 
-type Hashes struct {
-	SHA256 string `json:"sha256"`
-}
+// The first user ID given with -u. Set after parsing flags.
+var creator = new(string)
+var prefixArgs = flag.MakeFull("p", "prefix", "Prefix for the room ID. Can be specified multiple times or as a comma-separated list to accept any of the prefixes. Prefixes can be weighted as prefix=weight to keep searching until the highest-weighted one is found.", "").StringArray()
 
-var timestamp = flag.MakeFull("t", "timestamp", "Timestamp of the create event (defaults to current time)", strconv.FormatInt(time.Now().UnixMilli(), 10)).Int64()
-var creator = flag.MakeFull("u", "user_id", "User ID of the room creator", "").String()
-var prefix = flag.MakeFull("p", "prefix", "Prefix for the room ID", "").String()
-var createContent = flag.MakeFull("c", "content", "Create event content", `{"room_version":"12"}`).String()
+// All the prefixes given with -p joined with commas. Set after parsing flags.
+var prefix = new(string)
+var createContent = flag.MakeFull("c", "content", "Create event content, or @file to read it from a file or - to read it from stdin. Can use {{.Sender}}, {{.Timestamp}} and {{.ServerName}}.", `{"room_version":"12"}`).String()
+var roomVersion = flag.Make().LongKey("room-version").Usage("Room version to use instead of the room_version in the create content, only versions where the room ID is derived from the create event are supported").String()
+var unstableRoomVersions = flag.Make().LongKey("unstable-room-versions").Usage("Allow unknown room versions, e.g. unstable org.matrix.* versions, assuming they derive room IDs like room version 12").Default("false").Bool()
+var additionalCreators = flag.Make().LongKey("additional-creator").Usage("User ID to add to additional_creators in the create content. Can be specified multiple times or as a comma-separated list.").StringArray()
+var predecessor = flag.Make().LongKey("predecessor").Usage("Room ID of the room being upgraded, which is added as the predecessor in the create content").String()
+var homeserverURL = flag.Make().LongKey("homeserver").Usage("Homeserver URL for --create-room and for fetching the --predecessor room's create event, so that its room type and federation setting are carried forward (token is read from MATRIX_ACCESS_TOKEN)").String()
+var createRoom = flag.Make().LongKey("create-room").Usage("Create the room on the --homeserver with /createRoom after it's found").Default("false").Bool()
+var contentHashPrefix = flag.Make().LongKey("content-hash-prefix").Usage("Prefix that the content hash (hashes.sha256) of the create event must also start with").String()
+var suffix = flag.Make().LongKey("suffix").Usage("Suffix that the room ID must end with, can be combined with -p").String()
+var contains = flag.Make().LongKey("contains").Usage("String that must appear anywhere in the room ID").String()
+var regexPattern = flag.Make().LongKey("regex").Usage("Regular expression that the room ID (without the sigil) must match").String()
+var structure = flag.Make().LongKey("pattern").Usage("Structural pattern the start of the room ID must have: repeated:N or palindrome:N").String()
+var scoreMode = flag.Make().LongKey("score").Usage("Instead of matching, look for the best-scoring room ID within the time limit (aesthetic or pronounceable)").String()
+var pronounceable = flag.Make().LongKey("pronounceable").Usage("Look for a room ID starting with this many pronounceable characters, outputting the most pronounceable one found if the time limit is reached").Default("0").Int()
+var charset = flag.Make().LongKey("charset").Usage("Only accept room IDs made entirely of this charset: letters-only, alphanumeric, lowercase or uppercase").String()
+var noPunct = flag.Make().LongKey("no-punct").Usage("Reject room IDs containing - or _ (same as --charset=alphanumeric)").Default("false").Bool()
+var blocklist = flag.Make().LongKey("blocklist").Usage("File with substrings, one per line, that must not appear anywhere in the room ID (case-insensitive)").String()
+var matchExpr = flag.Make().LongKey("match-expr").Usage("CEL expression on the room ID (as id) that must evaluate to true, e.g. 'id.startsWith(\"Cat\") && !id.contains(\"_\")'").String()
+var matchScript = flag.Make().LongKey("match-script").Usage("Lua script defining a match(event_id, event_json) function that must return true for candidates accepted by the other matchers").String()
+var ignoreCase = flag.Make().LongKey("ignore-case").Usage("Match prefixes, --contains and --regex case-insensitively").Default("false").Bool()
+var fuzzyGlyphs = flag.Make().LongKey("fuzzy-glyphs").Usage("Treat characters that look alike (I/l/1, O/0, S/5, ...) as equivalent in prefixes").Default("false").Bool()
+var leet = flag.Make().LongKey("leet").Usage("Also accept leet-speak variants of the prefixes (o→0, e→3, i→1/l, s→5, a→4, t→7)").Default("false").Bool()
+var prefixFile = flag.Make().LongKey("prefix-file").Usage("File with acceptable prefixes, one per line. The file is watched for changes during the search.").String()
+var wordlist = flag.Make().LongKey("wordlist").Usage("File with words, one per line. Room IDs starting with any of the words are accepted.").String()
+var wordlistAnywhere = flag.Make().LongKey("wordlist-anywhere").Usage("Accept words from the --wordlist anywhere in the room ID instead of only at the start").Default("false").Bool()
+var minWordLength = flag.Make().LongKey("min-word-length").Usage("Ignore words shorter than this in the --wordlist").Default("4").Int()
 var threadCount = flag.MakeFull("k", "threads", "Number of threads to use for bruteforcing", "1").Uint16()
 var threadIndexStart = flag.MakeFull("i", "index-start", "Starting index for thread IDs (useful for running multiple instances)", "0").Uint16()
+var randomStart = flag.Make().LongKey("random-start").Usage("Start each thread's counter from a random value, so that uncoordinated instances don't duplicate work").Default("false").Bool()
+var randomThreadIDs = flag.Make().LongKey("random-thread-ids").Usage("Use random thread IDs instead of counting up from --index-start").Default("false").Bool()
 var logInterval = flag.MakeFull("l", "log-interval", "How many hashes to check before logging status?", "1000000").Uint32()
 var maxSeconds = flag.MakeFull("m", "max-seconds", "Time limit for the bruteforce in seconds (-1 for unlimited)", "30").Int()
+var refreshHours = flag.Make().LongKey("refresh-hours").Usage("Restart the search with a fresh timestamp after this many hours, abandoning progress on the old timestamp (0 to disable)").Default("0").Float64()
+var stragglerThreshold = flag.Make().LongKey("straggler-threshold").Usage("Warn about threads whose hashrate is below this fraction of the median (0 to disable)").Default("0.5").Float64()
+var rebalanceStragglers = flag.Make().LongKey("rebalance-stragglers").Usage("Split the remaining range of persistently slow threads between fresh workers, one for each healthy thread").Default("false").Bool()
+var processes = flag.Make().LongKey("processes").Usage("Run each thread in a separate child process instead of a goroutine").Default("false").Bool()
+var processNice = flag.Make().LongKey("process-nice").Usage("Nice value for worker processes (Linux only)").Default("0").Int()
+var processAffinity = flag.Make().LongKey("process-affinity").Usage("Pin each worker process to a single CPU core (Linux only)").Default("false").Bool()
+var dryRun = flag.Make().LongKey("dry-run").Usage("Print the event templates, keyspace and difficulty without starting any workers").Default("false").Bool()
+var bestEffort = flag.Make().LongKey("best-effort").Usage("If the time limit is reached without a match, output the candidate that matched the longest part of the prefix").Default("false").Bool()
+var chainMember = flag.Make().LongKey("chain-member").Usage("After finding the create event, also bruteforce the creator's join event so that it matches too").Default("false").Bool()
+var resultCount = flag.Make().LongKey("count").Usage("Keep searching until this many distinct matching create events have been found").Default("1").Int()
+var nearMissPath = flag.Make().LongKey("near-miss-log").Usage("Append every candidate that matches at least --near-miss-length characters of the prefix to this file").String()
+var nearMissLength = flag.Make().LongKey("near-miss-length").Usage("Number of leading characters a candidate must match to be logged with --near-miss-log (defaults to one less than the prefix)").Default("0").Int()
+var topCandidates = flag.Make().LongKey("top-candidates").Usage("Keep track of this many best near-miss candidates and print them as a ranked list at the end").Default("0").Int()
+var audit = flag.Make().LongKey("audit").Usage("Recompute the event ID of results with a second canonical JSON and hashing implementation and refuse to print them if it disagrees").Bool()
+var databasePath = flag.Make().LongKey("database").Usage("SQLite database to record runs and results in, and to reuse earlier results from").String()
+var listenAddress = flag.Make().LongKey("listen").Usage("Address for the pool server to listen on").Default("127.0.0.1:8080").String()
+var poolPerPrefix = flag.Make().LongKey("pool-per-prefix").Usage("Number of unclaimed create events the pool miner keeps for each prefix and sender").Default("10").Int()
+var poolQuota = flag.Make().LongKey("pool-quota").Usage("Maximum number of unclaimed create events in the pool, after which the pool miner pauses").Default("1000").Int()
+var idleHours = flag.Make().LongKey("idle-hours").Usage("Hours in local time when the pool miner runs, like 22-7").String()
+var outputPath = flag.MakeFull("o", "output", "File to write the result to in the --porcelain format, replacing it atomically", "").String()
+var journalPath = flag.Make().LongKey("journal").Usage("File to append every result to as NDJSON, with the time it was found").String()
+var cacheDir = flag.Make().LongKey("cache-dir").Usage("Directory for caching found results, so that repeating an identical search returns instantly").String()
+var summaryPath = flag.Make().LongKey("summary").Usage("Path to write a run summary to at the end of the run (Markdown if the file name ends with .md, JSON otherwise)").String()
+var uploadURL = flag.Make().LongKey("upload").Usage("Upload the result and summary to an S3 or GCS bucket (s3://bucket or gs://bucket)").String()
+var uploadKey = flag.Make().LongKey("upload-key").Usage("Object key template for uploads, supports {room_id}, {prefix}, {sender}, {timestamp} and {name}").Default("matrix-rig/{room_id}/{name}").String()
+var uploadEndpoint = flag.Make().LongKey("upload-endpoint").Usage("Custom endpoint for S3-compatible storage").String()
+var ntfyURL = flag.Make().LongKey("ntfy").Usage("ntfy topic URL to send notifications to when the search starts, ends and periodically in between").String()
+var webhookURL = flag.Make().LongKey("webhook-url").Usage("URL to POST the result or a failure summary to as JSON when the search ends").String()
+var matrixRoom = flag.Make().LongKey("matrix-room").Usage("Matrix room ID to send the result and progress updates to (uses --homeserver and MATRIX_ACCESS_TOKEN)").String()
+var progressInterval = flag.Make().LongKey("progress-interval").Usage("Minutes between progress updates sent to --ntfy and --matrix-room (0 to disable)").Default("60").Float64()
+var gotifyURL = flag.Make().LongKey("gotify").Usage("Gotify server URL to send a notification to when the search ends (token is read from GOTIFY_TOKEN)").String()
+var statusFile = flag.Make().LongKey("status-file").Usage("Path to a JSON file that is continuously updated with the progress of the search, or auto to use a path that the top command can find").String()
+var topOnce = flag.Make().LongKey("once").Usage("Print the status of running instances once instead of continuously refreshing in the top command").Default("false").Bool()
+var signingKeyPath = flag.Make().LongKey("signing-key").Usage("Path to a Synapse-format signing key file, or a file with a raw base64 ed25519 seed").String()
+var signingKeyID = flag.Make().LongKey("key-id").Usage("ID of the key in the signing key file to sign with (defaults to the first key)").String()
+var federateTo = flag.Make().LongKey("federate-to").Usage("Server name to send the signed event to over federation after it's found. Can be specified multiple times or as a comma-separated list.").StringArray()
+var keyVersion = flag.Make().LongKey("key-version").Usage("Version of the key to generate with the keys command (defaults to random)").String()
+var watts = flag.Make().LongKey("watts").Usage("Power draw in watts for energy estimates (defaults to measuring with RAPL where available)").Default("0").Float64()
+var pricePerKWh = flag.Make().LongKey("price-kwh").Usage("Electricity price per kWh for cost estimates").Default("0").Float64()
+var jsonErrors = flag.Make().LongKey("json").Usage("Write errors to stderr as JSON objects").Default("false").Bool()
+var porcelain = flag.Make().LongKey("porcelain").Usage("Only write the result to stdout, as a single JSON object in a stable format").Default("false").Bool()
+var outputNamespace = flag.Make().LongKey("output-namespace").Usage("Namespace of the room_id and origin_server_ts keys in the /createRoom request body (empty for unprefixed keys)").Default("fi.mau").String()
+var outputFormat = flag.Make().LongKey("output-format").Usage("Format of the found create event on stdout: default, synapse-admin (only the /createRoom request body), curl (a curl command that creates the room) or pdu (only the event)").Default("default").String()
+var verbose = flag.MakeFull("v", "verbose", "Include intermediate hash values in the result output", "false").Bool()
+var hashrate = flag.Make().LongKey("hashrate").Usage("Total hashrate in hashes per second to use for the simulate and estimate commands").Default("0").Float64()
+var estimateCloud = flag.Make().LongKey("cloud").Usage("Include cost estimates for common cloud instance types in the estimate command").Default("false").Bool()
+var simulateTrials = flag.Make().LongKey("trials").Usage("Number of Monte Carlo trials to run in the simulate command").Default("100000").Int()
+var idAlphabet = flag.Make().LongKey("id-alphabet").Usage("Base64 alphabet used for encoding event IDs, for experimental room versions").Default(base64URLAlphabet).String()
+var idPadding = flag.Make().LongKey("id-padding").Usage("Use padding when encoding event IDs, for experimental room versions").Default("false").Bool()
+var roomIDSigil = flag.Make().LongKey("sigil").Usage("Sigil to prepend to the event ID to form the room ID").Default("!").String()
 var wantHelp, _ = flag.MakeHelpFlag()
 
-const placeholderRandomness = "PLCEHOLD"
-const placeholderSHA256 = "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"
-
-var base64SHA256Length = base64.RawURLEncoding.EncodedLen(sha256.Size)
-
 const maxPrefixLength = 12 // arbitrarily picked number that is probably already impossible
 
 func main() {
 	flag.SetHelpTitles(
 		"matrix-rig - Vanity Room ID generator for Matrix.",
-		"matrix-rig [-h] [-t timestamp] [-u user_id] [-p prefix] [-c creation_content] [-k threads] [-l log_interval] [-m max_seconds]",
+		"matrix-rig [-h] [-t timestamp] [--timestamp-window=seconds] [--randomness-length=bytes] [--randomness-field=key] [--no-randomness] [-u user_id] [-p prefix] [--prefix-file=file] [--suffix=suffix] [--content-hash-prefix=prefix] [--wordlist=file [--min-word-length=n] [--wordlist-anywhere]] [--contains=string] [--regex=pattern] [--pattern=kind:n] [--pronounceable=n] [--score=scorer] [--charset=name] [--no-punct] [--blocklist=file] [--match-expr=expression] [--match-script=file] [--ignore-case] [--fuzzy-glyphs] [--leet] [-c creation_content] [--room-version=version [--unstable-room-versions]] [--additional-creator=user_id] [-k threads] [--random-start] [--random-thread-ids] [-l log_interval] [-m max_seconds] [-v] [--processes [--process-nice=n] [--process-affinity]] [--best-effort] [--count=n] [--chain-member] [--top-candidates=n] [--near-miss-log=file [--near-miss-length=n]] [--refresh-hours=n] [--cache-dir=dir] [--summary=file] [--upload=s3://bucket [--upload-key=template]] [--ntfy=url] [--gotify=url] [--webhook-url=url] [--matrix-room=room_id [--progress-interval=minutes]] [--status-file=file] [--signing-key=file [--key-id=id]] [--watts=n] [--price-kwh=n] [--json] [--porcelain]\n"+
+			"  matrix-rig simulate [-h] [-p prefix] [--contains=string] [--regex=pattern] [-m max_seconds] --hashrate=<hashes/s> [--trials=n] [--watts=n [--price-kwh=n]]\n"+
+			"  matrix-rig estimate [-h] [-p prefix] [--contains=string] [--regex=pattern] [--hashrate=<hashes/s>] [--cloud]\n"+
+			"  matrix-rig calibrate [-h] [-t timestamp] [-u user_id] [-p target] [-c creation_content] [-k threads] [-m seconds]\n"+
+			"  matrix-rig pdu [-h] [-p prefix] [--room-version=version] [-k threads] [-m max_seconds] [--signing-key=file] [--porcelain] < event.json\n"+
+			"  matrix-rig space [-h] [-t timestamp] [-u user_id] [-p prefix] [-c creation_content] [--children=n] [-k threads] [-m max_seconds] [--signing-key=file]\n"+
+			"  matrix-rig upgrade [-h] [-t timestamp] [-u user_id] [-p prefix] [-c creation_content] --predecessor=room_id --homeserver=url [-k threads] [-m max_seconds]\n"+
+			"  matrix-rig jobs [-h] [-t timestamp] [-u user_id] [-p prefix] [-c creation_content] [-k threads] [-m max_seconds] < jobs.ndjson\n"+
+			"  matrix-rig verify [-h] [-p prefix] [--porcelain] <event.json>\n"+
+			"  matrix-rig explain [-h] <event.json>\n"+
+			"  matrix-rig race [-h] [-t timestamp] [-u user_id] [-c creation_content] [-k threads] [-m max_seconds] <prefix> <prefix...>\n"+
+			"  matrix-rig variants [-h] [--hashrate=<hashes/s>] <word>\n"+
+			"  matrix-rig top [-h] [--once] [status file, glob or URL...]\n"+
+			"  matrix-rig pool <import|serve> [-h] --database=file [--listen=address]\n"+
+			"  matrix-rig pool mine [-h] --database=file [-u user_id] [-p prefix] [-c creation_content] [-m seconds_per_prefix] [--pool-per-prefix=n] [--pool-quota=n] [--idle-hours=start-end]\n"+
+			"  matrix-rig keys <generate|show> [-h] [--signing-key=file] [--key-version=version]",
 	)
 	err := flag.Parse()
 	if err != nil {
+		if *jsonErrors {
+			exitWithError(ExitUsage, err.Error())
+		}
 		_, _ = fmt.Fprintf(os.Stderr, err.Error())
 		flag.PrintHelp()
-		os.Exit(3)
+		os.Exit(ExitUsage)
 	} else if *wantHelp {
 		flag.PrintHelp()
-		os.Exit(3)
+		os.Exit(ExitUsage)
+	}
+	*prefix = strings.Join(targetPrefixes(), ",")
+	if creators := senders(); len(creators) > 0 {
+		*creator = string(creators[0])
+	}
+	if err = setupEventIDEncoding(); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	// Child workers get the template from the parent, and their stdin is used for the worker spec instead.
+	if flag.Arg(0) != "worker" {
+		if err = loadCreateContent(); err != nil {
+			fatal(ExitInvalidInput, err)
+		} else if err = fetchPredecessor(); err != nil {
+			fatal(ExitBackendFailure, err)
+		} else if err = parseContentTemplate(); err != nil {
+			fatal(ExitInvalidInput, err)
+		} else if *createContent, err = renderCreateContent(id.UserID(*creator), *timestamp); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	switch flag.Arg(0) {
+	case "":
+		runBruteforce()
+	case "simulate":
+		runSimulate()
+	case "estimate":
+		runEstimate()
+	case "calibrate":
+		runCalibrate()
+	case "pdu":
+		runPDU()
+	case "space":
+		runSpace()
+	case "upgrade":
+		runUpgrade()
+	case "jobs":
+		runJobs()
+	case "verify":
+		runVerify()
+	case "explain":
+		runExplain()
+	case "race":
+		runRace()
+	case "pool":
+		runPool()
+	case "keys":
+		runKeys()
+	case "top":
+		runTop()
+	case "variants":
+		runVariants()
+	case "worker":
+		runWorker()
+	default:
+		if *jsonErrors {
+			fatalf(ExitUsage, "Unknown command %s", flag.Arg(0))
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown command %s\n", flag.Arg(0))
+		flag.PrintHelp()
+		os.Exit(ExitUsage)
+	}
+}
+
+func runBruteforce() {
+	creatorUserIDs := senders()
+	for _, userID := range creatorUserIDs {
+		if _, _, err := userID.Parse(); err != nil {
+			fatalf(ExitInvalidInput, "Invalid user ID: %s", userID)
+		}
 	}
-	creatorUserID := id.UserID(*creator)
-	if _, _, err := creatorUserID.Parse(); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Invalid user ID: %s\n", *creator)
-		os.Exit(4)
+	if len(creatorUserIDs) == 0 {
+		fatalf(ExitInvalidInput, "Invalid user ID: %s", *creator)
+	} else if len(creatorUserIDs) > int(*threadCount) {
+		fatalf(ExitInvalidInput, "Searching over %d user IDs requires at least as many threads", len(creatorUserIDs))
 	} else if !json.Valid([]byte(*createContent)) {
-		_, _ = fmt.Fprintf(os.Stderr, "Invalid create event content\n")
-		os.Exit(4)
-	} else if len(*prefix) > maxPrefixLength {
-		_, _ = fmt.Fprintf(os.Stderr, "Prefix too long, must be at most %d characters\n", maxPrefixLength)
-		os.Exit(4)
-	}
-	createContentJSON := json.RawMessage(*createContent)
-	createContentJSON = exerrors.Must(sjson.SetBytes(createContentJSON, exgjson.Path("fi.mau.randomness"), placeholderRandomness))
-	createPDU := &CreatePDU{
-		AuthEvents:     []string{},
-		PrevEvents:     []string{},
-		Depth:          1,
-		Hashes:         nil,
-		OriginServerTS: *timestamp,
-		Sender:         creatorUserID,
-		StateKey:       "",
-		Type:           "m.room.create",
-		Content:        createContentJSON,
-	}
-	pduJSON := exerrors.Must(json.Marshal(createPDU))
-	pduJSON = canonicaljson.CanonicalJSONAssumeValid(pduJSON)
-	createPDU.Hashes = &Hashes{SHA256: placeholderSHA256}
-	pduJSONWithHashField := exerrors.Must(json.Marshal(createPDU))
-	pduJSONWithHashField = canonicaljson.CanonicalJSONAssumeValid(pduJSONWithHashField)
-	var wg sync.WaitGroup
+		fatalf(ExitInvalidInput, "Invalid create event content")
+	} else if *topCandidates > 0 && firstTargetPrefix() == "" && activeScorer() == "" {
+		fatalf(ExitInvalidInput, "--top-candidates requires a target prefix with -p")
+	} else if err := checkOutputFormat(); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkCreateRoomFlags(); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkMatrixNotifyFlags(); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if *resultCount < 1 {
+		fatalf(ExitInvalidInput, "--count must be at least 1")
+	} else if *chainMember && (*resultCount > 1 || *scoreMode != "") {
+		fatalf(ExitInvalidInput, "--chain-member can't be combined with --count or --score")
+	} else if err := checkRoomVersion(*createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkTemplateFlags(*timestamp, *createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if *scoreMode != "" && *maxSeconds < 0 {
+		fatalf(ExitInvalidInput, "--score requires a time limit with -m")
+	}
+	signingKey, err := loadSelectedSigningKey()
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to load signing key:", err)
+	} else if err = checkFederationFlags(signingKey); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	tpls, err := newSenderTemplates(creatorUserIDs, *timestamp)
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	for _, tpl := range tpls {
+		if err = checkEventSize(tpl, signingKey); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if *dryRun {
+		printDryRun(tpls, matcher, signingKey)
+		os.Exit(ExitFound)
+	}
+	script, err := loadScriptFilter()
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to load match script:", err)
+	}
+	weighted, err := newWeightedSearch()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if weighted != nil && *resultCount > 1 {
+		fatalf(ExitInvalidInput, "--count can't be combined with weighted prefixes")
+	}
+	nearMisses, err := openNearMissLog()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	if tpls[0].RandomnessLength == 0 {
+		warnKeyspace(tpls, matcher)
+	}
+	var foundLock sync.Mutex
+	// The results found so far with --count, protected by foundLock.
+	var found []*Candidate
+	seen := make(map[string]struct{})
+	var workers []*Worker
+	start := time.Now()
+	meter := startEnergyMeter()
+	var status *statusWriter
+	var resultCachePath string
+	if *cacheDir != "" {
+		resultCachePath = cachePath(creatorUserIDs, json.RawMessage(*createContent))
+	}
+	// emit outputs a result, must be called with foundLock held.
+	emit := func(c *Candidate) {
+		if resultCachePath != "" && !c.Cached && len(found) == 0 {
+			storeCachedResult(resultCachePath, c)
+		}
+		if signingKey != nil {
+			c.PDU = signPDU(c.PDU, c.Sender().Homeserver(), signingKey)
+		}
+		printResult(c)
+		federatePDU(c, signingKey)
+		if *createRoom {
+			if err := createRoomOnServer(c, createRoomRequest(c)); err != nil {
+				fatal(ExitBackendFailure, "Failed to create room:", err)
+			}
+		}
+		found = append(found, c)
+	}
+	finish := func(c *Candidate) {
+		foundLock.Lock()
+		emit(c)
+		if *chainMember {
+			stopWorkers(workers)
+			if member := mineChainedMember(c, matcher); member == nil {
+				_, _ = fmt.Fprintln(os.Stderr, "No matching join event found in", time.Duration(*maxSeconds)*time.Second)
+			} else {
+				if signingKey != nil {
+					member.PDU = signPDU(member.PDU, member.Sender().Homeserver(), signingKey)
+				}
+				printPDUResult(member)
+				federatePDU(member, signingKey)
+			}
+		}
+		energy := meter.Report(totalHashes(workers), matcher.Probability())
+		energy.Print()
+		status.Finish(OutcomeFound, workers, c)
+		printTopCandidates(workers)
+		writeSummary(OutcomeFound, start, workers, c, energy)
+		finishRunRecord(OutcomeFound, start, totalHashes(workers))
+		uploadArtifacts(c, *summaryPath)
+		notifyCompletion(OutcomeFound, c, totalHashes(workers), time.Since(start))
+		os.Exit(ExitFound)
+	}
+	onFound := func(c *Candidate) bool {
+		if script != nil {
+			if ok, err := script.Accept(c); err != nil {
+				fatal(ExitInvalidInput, "Match script failed:", err)
+			} else if !ok {
+				return true
+			}
+		}
+		if weighted != nil && !weighted.Offer(c) {
+			return true
+		}
+		if *resultCount > 1 {
+			foundLock.Lock()
+			if _, dup := seen[c.EventID]; dup {
+				foundLock.Unlock()
+				return true
+			}
+			seen[c.EventID] = struct{}{}
+			if len(found)+1 < *resultCount {
+				emit(c)
+				_, _ = fmt.Fprintf(os.Stderr, "Found %d/%d results, continuing\n", len(found), *resultCount)
+				foundLock.Unlock()
+				return true
+			}
+			foundLock.Unlock()
+		}
+		finish(c)
+		return false
+	}
+	if err = openDatabase(); err != nil {
+		fatal(ExitBackendFailure, err)
+	}
+	startRunRecord(start)
+	if resultCachePath != "" {
+		if cached := loadCachedResult(resultCachePath, matcher); cached != nil {
+			onFound(cached)
+		}
+	}
+	if cached := loadDatabaseResult(cacheSpecHash(creatorUserIDs, json.RawMessage(*createContent)), matcher); cached != nil {
+		onFound(cached)
+	}
+	currentWorkers := func() []*Worker {
+		foundLock.Lock()
+		defer foundLock.Unlock()
+		return slices.Clone(workers)
+	}
+	if *statusFile != "" {
+		statusPath, err := resolveStatusFile(*statusFile)
+		if err != nil {
+			fatal(ExitBackendFailure, "Failed to create status directory:", err)
+		}
+		status = startStatusWriter(statusPath, start, matcher, currentWorkers)
+	}
+	notifyStart(matcher)
+	startProgressNotifier(start, matcher, currentWorkers)
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		foundLock.Lock()
+		energy := meter.Report(totalHashes(workers), matcher.Probability())
+		energy.Print()
+		status.Finish(OutcomeInterrupted, workers, nil)
+		printTopCandidates(workers)
+		writeSummary(OutcomeInterrupted, start, workers, nil, energy)
+		finishRunRecord(OutcomeInterrupted, start, totalHashes(workers))
+		notifyCompletion(OutcomeInterrupted, nil, totalHashes(workers), time.Since(start))
+		exitWithError(ExitInterrupted, "Interrupted")
+	}()
+	var deadline, refresh <-chan time.Time
+	if *maxSeconds >= 0 {
+		deadline = time.After(time.Duration(*maxSeconds) * time.Second)
+	}
+	var outcome, outcomeMessage string
+Loop:
 	for {
 		if int(*threadIndexStart)+int(*threadCount) > math.MaxUint16 {
-			_, _ = fmt.Fprintf(os.Stderr, "Thread index %d + %d exceeds uint16 limit\n", *threadIndexStart, *threadCount)
+			outcomeMessage = fmt.Sprintf("Thread index %d + %d exceeds uint16 limit", *threadIndexStart, *threadCount)
+			outcome = OutcomeExhausted
 			break
 		}
-		wg.Add(int(*threadCount))
-		for i := uint16(0); i < *threadCount; i++ {
-			go doBruteforce(*threadIndexStart+i, bytes.Clone(pduJSON), bytes.Clone(pduJSONWithHashField), []byte(*prefix), *logInterval, wg.Done)
-			time.Sleep(time.Duration(500 / *threadCount) * time.Millisecond)
+		var wg sync.WaitGroup
+		roundWorkers := newWorkers(tpls, matcher, onFound)
+		for i, w := range roundWorkers {
+			if scorer := activeScorer(); scorer != "" {
+				w.Scorer = scorer
+				w.TopCandidates = *topCandidates
+			} else if *bestEffort || *topCandidates > 0 || nearMisses != nil {
+				w.BestEffortTarget = []byte(firstTargetPrefix())
+				w.TopCandidates = *topCandidates
+			}
+			if nearMisses != nil {
+				w.NearMissLength = nearMisses.length
+				w.OnNearMiss = nearMisses.Log
+			}
+			w.Process = *processes
+			if *processAffinity {
+				w.Affinity = i % runtime.NumCPU()
+			}
 		}
-		if *maxSeconds < 0 {
+		foundLock.Lock()
+		workers = append(workers, roundWorkers...)
+		foundLock.Unlock()
+		startWorkers(roundWorkers, &wg)
+		roundDone := make(chan struct{})
+		go func() {
 			wg.Wait()
-			fmt.Println("No solutions found, incrementing thread index start")
+			close(roundDone)
+		}()
+		monitorStop := make(chan struct{})
+		monitorDone := make(chan struct{})
+		go func() {
+			defer close(monitorDone)
+			monitorStragglers(roundWorkers, &wg, func(replacement *Worker) {
+				foundLock.Lock()
+				workers = append(workers, replacement)
+				foundLock.Unlock()
+				roundWorkers = append(roundWorkers, replacement)
+			}, monitorStop)
+		}()
+		if *refreshHours > 0 && refresh == nil {
+			refresh = time.After(time.Duration(*refreshHours * float64(time.Hour)))
+		}
+		select {
+		case <-roundDone:
+			close(monitorStop)
+			if tpls[0].RandomnessLength == 0 {
+				// Thread IDs aren't part of the event without randomness, so a new round would just repeat the same events.
+				outcomeMessage = "No solution found in the timestamp window"
+				outcome = OutcomeExhausted
+				break Loop
+			}
+			_, _ = fmt.Fprintln(os.Stderr, "No solutions found, incrementing thread index start")
 			*threadIndexStart += *threadCount
-		} else {
-			timeLimit := time.Duration(*maxSeconds) * time.Second
-			time.Sleep(timeLimit)
-			fmt.Println("No solution found in", timeLimit)
-			break
+		case <-refresh:
+			refresh = nil
+			close(monitorStop)
+			<-monitorDone
+			stopWorkers(roundWorkers)
+			<-roundDone
+			*timestamp = time.Now().UnixMilli()
+			if tpls, err = newSenderTemplates(creatorUserIDs, *timestamp); err != nil {
+				fatal(ExitInvalidInput, err)
+			}
+			_, _ = fmt.Fprintln(os.Stderr, "Restarting search with refreshed timestamp", *timestamp)
+		case <-deadline:
+			if best := weighted.Best(); best != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "Time limit reached, outputting the highest-weighted match found")
+				finish(best)
+			}
+			outcomeMessage = fmt.Sprint("No solution found in ", time.Duration(*maxSeconds)*time.Second)
+			if len(found) > 0 {
+				outcomeMessage = fmt.Sprintf("Only found %d/%d results in %s", len(found), *resultCount, time.Duration(*maxSeconds)*time.Second)
+			}
+			outcome = OutcomeTimeout
+			break Loop
 		}
 	}
-	os.Exit(1)
-}
-
-func doBruteforce(threadID uint16, pduJSON, pduJSONWithHashField, prefix []byte, chunkSize uint32, doneFunc func()) {
-	defer doneFunc()
-	pduRandomIndex := bytes.Index(pduJSON, []byte(placeholderRandomness))
-	pduHashRandomIndex := bytes.Index(pduJSONWithHashField, []byte(placeholderRandomness))
-	pduHashIndex := bytes.Index(pduJSONWithHashField, []byte(placeholderSHA256))
-
-	var i, chunks uint32
-	maxChunks := math.MaxUint32 / chunkSize
-	// 2 byte thread ID + 4 byte counter
-	randomness := make([]byte, 6)
-	binary.BigEndian.PutUint16(randomness[0:2], threadID)
-	unsafeRandomnessUint32 := (*uint32)(unsafe.Pointer(&randomness[2]))
-	randomnessEncodedLength := base64.RawURLEncoding.EncodedLen(len(randomness))
-	if len(placeholderRandomness) != randomnessEncodedLength {
-		panic("Placeholder randomness length mismatch")
-	}
-	pduRandomSlot := pduJSON[pduRandomIndex : pduRandomIndex+randomnessEncodedLength]
-	pduWithHashRandomSlot := pduJSONWithHashField[pduHashRandomIndex : pduHashRandomIndex+randomnessEncodedLength]
-	pduHashSlot := pduJSONWithHashField[pduHashIndex : pduHashIndex+base64SHA256Length]
-
-	hasher := sha256.New()
-	hashContainer := make([]byte, sha256.Size)
-	eventID := make([]byte, base64SHA256Length)
-
-	start := time.Now()
-	lastChunk := start
-	for {
-		i++
-		base64.RawURLEncoding.Encode(pduRandomSlot, randomness)
-		copy(pduWithHashRandomSlot, pduRandomSlot)
-		hasher.Reset()
-		hasher.Write(pduJSON)
-		hasher.Sum(hashContainer[:0])
-		base64.RawStdEncoding.Encode(pduHashSlot, hashContainer)
-		hasher.Reset()
-		hasher.Write(pduJSONWithHashField)
-		hasher.Sum(hashContainer[:0])
-		base64.RawURLEncoding.Encode(eventID, hashContainer)
-		if bytes.HasPrefix(eventID, prefix) {
-			formedRoomID := id.RoomID(fmt.Sprintf("!%s", eventID))
-			_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "iterated over", chunks*chunkSize+i, "hashes in", time.Since(start).String(), "and found", formedRoomID)
-			fmt.Println(string(pduJSONWithHashField))
-
-			createContentJSON := gjson.GetBytes(pduJSON, "content").Raw
-			roomVersion := gjson.Get(createContentJSON, "room_version").Str
-			createContentJSON = exerrors.Must(sjson.Delete(createContentJSON, "room_version"))
-			_ = json.NewEncoder(os.Stdout).Encode(map[string]any{
-				"fi.mau.origin_server_ts": *timestamp,
-				"fi.mau.room_id":          formedRoomID,
-				"creation_content":        json.RawMessage(createContentJSON),
-				"room_version":            roomVersion,
-			})
-			os.Exit(0)
-		}
-		if i == chunkSize {
-			dur := time.Since(lastChunk)
-			_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "checkpoint", chunks, "checked", chunkSize, "hashes,", (dur / time.Duration(chunkSize)).String(), "per hash")
-			i = 0
-			chunks++
-			lastChunk = time.Now()
-			if chunks >= maxChunks {
-				_, _ = fmt.Fprintln(os.Stderr, "Thread ID", threadID, "reached maximum chunks of", maxChunks, "after", time.Since(start).String())
-				break
+	foundLock.Lock()
+	var best *Candidate
+	if (*bestEffort || activeScorer() != "") && outcome == OutcomeTimeout {
+		if best = bestCandidate(workers); best != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Outputting best candidate, which %s\n", describeScore(best))
+			if signingKey != nil {
+				best.PDU = signPDU(best.PDU, best.Sender().Homeserver(), signingKey)
+			}
+			printResult(best)
+			// Scorers don't have a target to reach, so the best candidate within the time limit is the result of the search.
+			if activeScorer() != "" {
+				outcome = OutcomeFound
 			}
 		}
-		*unsafeRandomnessUint32++
 	}
+	energy := meter.Report(totalHashes(workers), matcher.Probability())
+	energy.Print()
+	status.Finish(outcome, workers, best)
+	printTopCandidates(workers)
+	writeSummary(outcome, start, workers, best, energy)
+	finishRunRecord(outcome, start, totalHashes(workers))
+	uploadArtifacts(best, *summaryPath)
+	notifyCompletion(outcome, best, totalHashes(workers), time.Since(start))
+	if outcome == OutcomeFound {
+		os.Exit(ExitFound)
+	}
+	exitWithError(ExitNotFound, outcomeMessage)
 }
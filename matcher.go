@@ -0,0 +1,438 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"unsafe"
+)
+
+// Matcher decides whether a generated event ID (unpadded base64url, without the sigil) is acceptable.
+type Matcher interface {
+	Match(eventID []byte) bool
+	// Probability returns the chance that a single uniformly random event ID is accepted.
+	Probability() float64
+}
+
+// rawTargetPrefixes returns the prefixes given with -p, splitting comma-separated lists but keeping weights.
+func rawTargetPrefixes() (prefixes []string) {
+	for _, arg := range *prefixArgs {
+		for _, p := range strings.Split(arg, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return
+}
+
+// targetPrefixes returns the prefixes given with -p without their weights.
+func targetPrefixes() (prefixes []string) {
+	for _, arg := range rawTargetPrefixes() {
+		prefix, _ := splitPrefixWeight(arg)
+		prefixes = append(prefixes, prefix)
+	}
+	return
+}
+
+// firstTargetPrefix returns the first prefix given with -p, which is used as the target for near-miss tracking.
+func firstTargetPrefix() string {
+	if prefixes := targetPrefixes(); len(prefixes) > 0 {
+		return prefixes[0]
+	}
+	return ""
+}
+
+// buildMatcher creates the matcher specified by the command-line flags.
+func buildMatcher() (*CompiledMatcher, error) {
+	var matchers []Matcher
+	prefixStrings := targetPrefixes()
+	if *leet {
+		var err error
+		if prefixStrings, err = expandLeetPrefixes(prefixStrings); err != nil {
+			return nil, err
+		}
+	}
+	var extra []Matcher
+	if *wordlist != "" {
+		if *minWordLength < 1 {
+			return nil, fmt.Errorf("--min-word-length must be positive")
+		}
+		words, err := readWordlist(*wordlist, *minWordLength, maxPrefixLength)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read wordlist: %w", err)
+		}
+		wm := NewAhoCorasickMatcher(words, *ignoreCase, *wordlistAnywhere)
+		if wm.Len() == 0 {
+			return nil, fmt.Errorf("no usable words of at least %d characters found in %s", *minWordLength, *wordlist)
+		}
+		extra = append(extra, wm)
+	}
+	if *prefixFile != "" {
+		rm, err := newPrefixFileMatcher(*prefixFile, prefixStrings, extra)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prefix file: %w", err)
+		}
+		matchers = append(matchers, rm)
+	} else if m, err := newAlternativesMatcher(prefixStrings, extra); err != nil {
+		return nil, err
+	} else if m != nil {
+		matchers = append(matchers, m)
+	}
+	if *suffix != "" {
+		sm, err := NewSuffixMatcher(*suffix, *ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --suffix: %w", err)
+		}
+		matchers = append(matchers, sm)
+	}
+	if *contains != "" {
+		if err := checkAlphabet(*contains); err != nil {
+			return nil, fmt.Errorf("invalid --contains value: %w", err)
+		} else if len(*contains) > maxPrefixLength {
+			return nil, fmt.Errorf("--contains value too long, must be at most %d characters", maxPrefixLength)
+		}
+		if *ignoreCase {
+			matchers = append(matchers, NewFoldContainsMatcher(*contains))
+		} else {
+			matchers = append(matchers, ContainsMatcher(*contains))
+		}
+	}
+	if *regexPattern != "" {
+		pattern := *regexPattern
+		if *ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		rm, err := NewRegexMatcher(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		matchers = append(matchers, rm)
+	}
+	if *noPunct && *charset == "" {
+		*charset = "alphanumeric"
+	}
+	if *charset != "" {
+		cm, err := NewCharsetMatcher(*charset)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, cm)
+	}
+	if *blocklist != "" {
+		words, err := readWordlist(*blocklist, 1, eventIDLength())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blocklist: %w", err)
+		}
+		matchers = append(matchers, NotMatcher{NewAhoCorasickMatcher(words, true, true)})
+	}
+	if *pronounceable > 0 {
+		if *pronounceable >= eventIDLength() {
+			return nil, fmt.Errorf("--pronounceable must be less than %d", eventIDLength())
+		}
+		matchers = append(matchers, PronounceableMatcher(*pronounceable))
+	}
+	if *structure != "" {
+		sm, err := NewStructureMatcher(*structure, *ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, sm)
+	}
+	if *matchExpr != "" {
+		em, err := NewExprMatcher(*matchExpr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match expression: %w", err)
+		}
+		matchers = append(matchers, em)
+	}
+	var cm *CompiledMatcher
+	if *scoreMode != "" {
+		if _, ok := scorers[*scoreMode]; !ok {
+			return nil, fmt.Errorf("unknown scorer %q, supported scorers are %s", *scoreMode, scorerNames())
+		} else if len(matchers) > 0 {
+			return nil, fmt.Errorf("--score can't be combined with other constraints, as it only looks for the best-scoring room ID")
+		}
+		// Scoring happens in the near-miss tracking of the workers, so nothing should ever be treated as a match.
+		matchers = append(matchers, NotMatcher{PrefixMatcher(nil)})
+	}
+	switch len(matchers) {
+	case 0:
+		cm = CompileMatcher(PrefixMatcher(nil))
+	case 1:
+		cm = CompileMatcher(matchers[0])
+	default:
+		cm = CompileMatcher(AllMatcher(matchers))
+	}
+	if *contentHashPrefix != "" {
+		if err := cm.setContentHashPrefix(*contentHashPrefix); err != nil {
+			return nil, fmt.Errorf("invalid --content-hash-prefix: %w", err)
+		}
+	}
+	return cm, nil
+}
+
+// parsePrefix returns a matcher for the given prefix, which may be a wildcard pattern,
+// taking --ignore-case and --fuzzy-glyphs into account.
+func parsePrefix(prefix string) (m Matcher, err error) {
+	length := len(prefix)
+	if isPattern(prefix) || *fuzzyGlyphs {
+		var pm *PatternMatcher
+		if pm, err = NewPatternMatcher(prefix, *ignoreCase, *fuzzyGlyphs); err != nil {
+			return nil, err
+		}
+		m, length = pm, pm.Len()
+	} else if err = checkAlphabet(prefix); err != nil {
+		return nil, err
+	} else if *ignoreCase {
+		m = NewFoldPrefixMatcher(prefix)
+	} else {
+		m = PrefixMatcher(prefix)
+	}
+	if length > maxPrefixLength {
+		return nil, fmt.Errorf("prefix %s too long, must be at most %d characters", prefix, maxPrefixLength)
+	}
+	return m, nil
+}
+
+func parsePrefixes(prefixes []string) ([]Matcher, error) {
+	matchers := make([]Matcher, len(prefixes))
+	for i, p := range prefixes {
+		var err error
+		if matchers[i], err = parsePrefix(p); err != nil {
+			return nil, err
+		}
+	}
+	return matchers, nil
+}
+
+// LiteralPrefixer can be implemented by matchers that only accept IDs starting with a fixed literal string.
+// The literal prefix is used to build the cheap first stage filter in front of the full matcher.
+type LiteralPrefixer interface {
+	LiteralPrefix() []byte
+}
+
+// MaskedPrefixer is a more general form of LiteralPrefixer for matchers that only accept IDs
+// where the first bytes ANDed with the mask are equal to the value.
+type MaskedPrefixer interface {
+	MaskedPrefix() (value, mask []byte)
+}
+
+// maskedPrefixOf returns the masked prefix of the given matcher, or nil if it doesn't have one.
+func maskedPrefixOf(m Matcher) (value, mask []byte) {
+	switch typed := m.(type) {
+	case MaskedPrefixer:
+		return typed.MaskedPrefix()
+	case LiteralPrefixer:
+		value = typed.LiteralPrefix()
+		return value, bytes.Repeat([]byte{0xff}, len(value))
+	default:
+		return nil, nil
+	}
+}
+
+type PrefixMatcher []byte
+
+var _ LiteralPrefixer = PrefixMatcher(nil)
+
+func (pm PrefixMatcher) Match(eventID []byte) bool {
+	return bytes.HasPrefix(eventID, pm)
+}
+
+func (pm PrefixMatcher) Probability() float64 {
+	return charProbability(len(pm))
+}
+
+func (pm PrefixMatcher) LiteralPrefix() []byte {
+	return pm
+}
+
+// ContainsMatcher accepts event IDs that contain the given string anywhere.
+type ContainsMatcher []byte
+
+func (cm ContainsMatcher) Match(eventID []byte) bool {
+	return bytes.Contains(eventID, cm)
+}
+
+func (cm ContainsMatcher) Probability() float64 {
+	positions := eventIDLength() - len(cm) + 1
+	if positions <= 0 {
+		return 0
+	}
+	// This treats each position as independent, which slightly overestimates the chance for strings
+	// that can overlap themselves, but is very close for anything longer than a couple of characters.
+	return -math.Expm1(float64(positions) * math.Log1p(-charProbability(len(cm))))
+}
+
+// NotMatcher accepts event IDs that are rejected by the given matcher.
+type NotMatcher struct {
+	m Matcher
+}
+
+func (nm NotMatcher) Match(eventID []byte) bool {
+	return !nm.m.Match(eventID)
+}
+
+func (nm NotMatcher) Probability() float64 {
+	return 1 - nm.m.Probability()
+}
+
+// AllMatcher accepts event IDs that are accepted by every one of the given matchers.
+type AllMatcher []Matcher
+
+var _ MaskedPrefixer = AllMatcher(nil)
+
+func (am AllMatcher) Match(eventID []byte) bool {
+	for _, m := range am {
+		if !m.Match(eventID) {
+			return false
+		}
+	}
+	return true
+}
+
+// Probability returns the product of the probabilities of the individual matchers, i.e. it assumes they're independent.
+func (am AllMatcher) Probability() float64 {
+	p := 1.0
+	for _, m := range am {
+		p *= m.Probability()
+	}
+	return p
+}
+
+// MaskedPrefix returns the longest masked prefix of any of the matchers, as all of them must match.
+func (am AllMatcher) MaskedPrefix() (value, mask []byte) {
+	for _, m := range am {
+		if mValue, mMask := maskedPrefixOf(m); len(mValue) > len(value) {
+			value, mask = mValue, mMask
+		}
+	}
+	return
+}
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// The standard base64 alphabet, which is used for content hashes.
+const base64StdAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// charProbability returns the chance of n specific base64url characters being generated.
+func charProbability(n int) float64 {
+	return math.Pow(64, -float64(n))
+}
+
+func commonPrefixLength(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+const fastCompareLength = 8
+
+// fastCompare checks the first 8 bytes of the event ID against a literal using a single uint64 comparison.
+type fastCompare struct {
+	mask  uint64
+	value uint64
+}
+
+func newFastCompare(prefix, prefixMask []byte) fastCompare {
+	var mask, value [fastCompareLength]byte
+	copy(value[:], prefix)
+	copy(mask[:], prefixMask)
+	return fastCompare{
+		mask:  *(*uint64)(unsafe.Pointer(&mask)),
+		value: *(*uint64)(unsafe.Pointer(&value)),
+	}
+}
+
+func (fc fastCompare) Match(eventID []byte) bool {
+	return *(*uint64)(unsafe.Pointer(&eventID[0]))&fc.mask == fc.value
+}
+
+// newRawCompare converts the literal characters of a masked prefix into a bit pattern over the raw hash,
+// so that hashes can be rejected before they're encoded into event IDs. Each character of the event ID
+// encodes 6 bits of the hash, so the first 8 bytes of the hash cover a bit over 10 characters.
+// Characters that aren't fully literal are skipped, as their accepted set of alphabet indexes isn't a bit pattern.
+func newRawCompare(prefix, prefixMask []byte, alphabet string) fastCompare {
+	var mask, value [fastCompareLength]byte
+	for i := 0; i < len(prefix) && i*6 < fastCompareLength*8; i++ {
+		idx := strings.IndexByte(alphabet, prefix[i])
+		if prefixMask[i] != 0xff || idx == -1 {
+			continue
+		}
+		for bit := 0; bit < 6; bit++ {
+			pos := i*6 + bit
+			if pos >= fastCompareLength*8 {
+				break
+			}
+			mask[pos/8] |= 1 << (7 - pos%8)
+			if idx&(1<<(5-bit)) != 0 {
+				value[pos/8] |= 1 << (7 - pos%8)
+			}
+		}
+	}
+	return newFastCompare(value[:], mask[:])
+}
+
+// CompiledMatcher is a two-stage matcher: the fast compare is done on every hash,
+// while the full matcher is only invoked for candidates that pass the fast compare.
+type CompiledMatcher struct {
+	// The fast compare translated to the raw hash, which is checked before encoding the event ID.
+	raw  fastCompare
+	fast fastCompare
+	full Matcher
+	// If true, the fast compare is exact and the full matcher doesn't need to be checked.
+	exact bool
+	// The required prefix of the content hash as a bit pattern over the raw hash, see --content-hash-prefix.
+	content            *fastCompare
+	contentProbability float64
+}
+
+func CompileMatcher(m Matcher) *CompiledMatcher {
+	value, mask := maskedPrefixOf(m)
+	cm := &CompiledMatcher{full: m, raw: newRawCompare(value, mask, eventIDAlphabet)}
+	if len(value) > fastCompareLength {
+		cm.fast = newFastCompare(value[:fastCompareLength], mask[:fastCompareLength])
+	} else {
+		cm.fast = newFastCompare(value, mask)
+		switch typed := m.(type) {
+		case PrefixMatcher, FoldPrefixMatcher:
+			cm.exact = true
+		case *PatternMatcher:
+			cm.exact = typed.exact
+		}
+	}
+	return cm
+}
+
+// MatchRaw checks whether the raw hash could be accepted by the matcher once encoded.
+// It never rejects hashes that would match, but may accept ones that don't.
+func (cm *CompiledMatcher) MatchRaw(hash []byte) bool {
+	return cm.raw.Match(hash)
+}
+
+func (cm *CompiledMatcher) Match(eventID []byte) bool {
+	return cm.fast.Match(eventID) && (cm.exact || cm.full.Match(eventID))
+}
+
+// MatchContentHash checks whether the raw content hash has the required prefix, if there is one.
+func (cm *CompiledMatcher) MatchContentHash(hash []byte) bool {
+	return cm.content == nil || cm.content.Match(hash)
+}
+
+func (cm *CompiledMatcher) Probability() float64 {
+	if cm.content != nil {
+		return cm.full.Probability() * cm.contentProbability
+	}
+	return cm.full.Probability()
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func newTestRand() *rand.Rand {
+	return rand.New(rand.NewPCG(0x6d617472, 0x69782d72))
+}
+
+func randomHash(rng *rand.Rand) []byte {
+	hash := make([]byte, sha256.Size)
+	for i := range hash {
+		hash[i] = byte(rng.Uint32())
+	}
+	return hash
+}
+
+func TestNewRawCompare(t *testing.T) {
+	rng := newTestRand()
+	for range 1000 {
+		hash := randomHash(rng)
+		eventID := eventIDEncoding.EncodeToString(hash)
+		for n := 1; n <= 12; n++ {
+			value, mask := []byte(eventID[:n]), []byte(strings.Repeat("\xff", n))
+			raw := newRawCompare(value, mask, eventIDAlphabet)
+			if !raw.Match(hash) {
+				t.Fatalf("raw compare for %s rejected the hash of %s", value, eventID)
+			}
+			// Flipping any bit covered by a literal character must change the encoded ID, so the hash must be rejected.
+			bit := rng.IntN(min(n*6, fastCompareLength*8))
+			flipped := append([]byte(nil), hash...)
+			flipped[bit/8] ^= 1 << (7 - bit%8)
+			if raw.Match(flipped) {
+				t.Fatalf("raw compare for %s accepted %s after flipping bit %d", value, eventIDEncoding.EncodeToString(flipped), bit)
+			}
+		}
+	}
+}
+
+func TestNewRawCompareSkipsMaskedCharacters(t *testing.T) {
+	rng := newTestRand()
+	value, mask := foldPattern("ab-c")
+	raw := newRawCompare(value, mask, eventIDAlphabet)
+	full := NewFoldPrefixMatcher("ab-c")
+	for range 100000 {
+		hash := randomHash(rng)
+		if !raw.Match(hash) && full.Match([]byte(eventIDEncoding.EncodeToString(hash))) {
+			t.Fatalf("raw compare rejected %s", eventIDEncoding.EncodeToString(hash))
+		}
+	}
+	// Force a match by writing the literal in a random case and check that it's always accepted.
+	for range 1000 {
+		hash := randomHash(rng)
+		eventID := []byte(eventIDEncoding.EncodeToString(hash))
+		for i, char := range []byte("ab-c") {
+			if isASCIILetter(char) && rng.IntN(2) == 0 {
+				char &= caseFoldMask
+			}
+			eventID[i] = char
+		}
+		decoded, err := eventIDEncoding.DecodeString(string(eventID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !raw.Match(decoded) {
+			t.Fatalf("raw compare rejected %s", eventID)
+		}
+	}
+}
+
+func TestFoldPattern(t *testing.T) {
+	value, mask := foldPattern("aB1-_z")
+	if string(value) != "AB1-_Z" {
+		t.Errorf("unexpected fold value %q", value)
+	}
+	if expected := []byte{caseFoldMask, caseFoldMask, 0xff, 0xff, 0xff, caseFoldMask}; string(mask) != string(expected) {
+		t.Errorf("unexpected fold mask %x", mask)
+	}
+	if p, expected := foldProbability(mask), charProbability(6)*8; p != expected {
+		t.Errorf("expected probability %g, got %g", expected, p)
+	}
+}
+
+// TestCaseFoldMask checks that the masked comparison accepts exactly the two cases of each letter for every byte.
+func TestCaseFoldMask(t *testing.T) {
+	for letter := byte('A'); letter <= 'Z'; letter++ {
+		for b := 0; b < 256; b++ {
+			expected := byte(b) == letter || byte(b) == letter|0x20
+			if got := byte(b)&caseFoldMask == letter; got != expected {
+				t.Errorf("masking %q for %q: expected %t, got %t", byte(b), letter, expected, got)
+			}
+		}
+	}
+}
+
+// testMatchers returns a set of matchers that cover all the different fast compare paths of CompiledMatcher.
+func testMatchers(t *testing.T) map[string]Matcher {
+	pattern, err := NewPatternMatcher("A[bc]?d", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longPattern, err := NewPatternMatcher("[AB]bcdefgh[ij]", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Matcher{
+		"Prefix":      PrefixMatcher("Ab"),
+		"LongPrefix":  PrefixMatcher("Abcdefghijk"),
+		"EmptyPrefix": PrefixMatcher(nil),
+		"FoldPrefix":  NewFoldPrefixMatcher("ab-"),
+		"Pattern":     pattern,
+		"LongPattern": longPattern,
+		"Contains":    ContainsMatcher("ab"),
+		"Not":         NotMatcher{PrefixMatcher("A")},
+		"All":         AllMatcher{PrefixMatcher("A"), ContainsMatcher("b")},
+		"AhoCorasick": NewAhoCorasickMatcher([]string{"Ab", "Ac", "B-", "Abcdefghij"}, true, false),
+	}
+}
+
+// TestCompiledMatcher checks that the compiled fast compares never change the result of the full matcher,
+// with both random event IDs and ones that are forced to share a prefix with a match.
+func TestCompiledMatcher(t *testing.T) {
+	rng := newTestRand()
+	prefixes := []string{"Ab", "ab-", "AB", "Abcdefghijk", "Abcd", "Acxd", "ABCDEFGHIJ", "bbcdefghi", "B-", "Bbcdefghj"}
+	for name, m := range testMatchers(t) {
+		t.Run(name, func(t *testing.T) {
+			cm := CompileMatcher(m)
+			for i := range 20000 {
+				hash := randomHash(rng)
+				eventID := []byte(eventIDEncoding.EncodeToString(hash))
+				if i%2 == 0 {
+					copy(eventID, prefixes[rng.IntN(len(prefixes))])
+					var err error
+					if hash, err = eventIDEncoding.DecodeString(string(eventID)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				expected := m.Match(eventID)
+				if got := cm.Match(eventID); got != expected {
+					t.Fatalf("compiled matcher returned %t for %s, full matcher %t", got, eventID, expected)
+				}
+				if expected && !cm.MatchRaw(hash) {
+					t.Fatalf("raw compare rejected %s", eventID)
+				}
+			}
+		})
+	}
+}
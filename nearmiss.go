@@ -0,0 +1,67 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// nearMissLog writes near-miss candidates to a file as they're found, one porcelain JSON object per line.
+type nearMissLog struct {
+	lock   sync.Mutex
+	file   *os.File
+	length int
+}
+
+// openNearMissLog opens the file given with --near-miss-log for appending, or returns nil if it wasn't set.
+func openNearMissLog() (*nearMissLog, error) {
+	if *nearMissPath == "" {
+		return nil, nil
+	}
+	length := *nearMissLength
+	if *scoreMode != "" {
+		// There's no target to derive a default from, so any score is fine as long as it's given explicitly.
+		if length < 1 {
+			return nil, fmt.Errorf("--near-miss-log with --score requires a minimum score with --near-miss-length")
+		}
+	} else {
+		target := len(firstTargetPrefix())
+		if *pronounceable > 0 {
+			target = *pronounceable
+		} else if target == 0 {
+			return nil, fmt.Errorf("--near-miss-log requires a target prefix with -p")
+		}
+		if length == 0 {
+			length = target - 1
+		}
+		if length < 1 || length > target {
+			return nil, fmt.Errorf("--near-miss-length must be between 1 and %d", target)
+		}
+	}
+	file, err := os.OpenFile(*nearMissPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open near miss log: %w", err)
+	}
+	return &nearMissLog{file: file, length: length}, nil
+}
+
+// Log appends the candidate to the file. Errors are only printed, as they shouldn't stop the search.
+func (nml *nearMissLog) Log(c *Candidate) {
+	data, err := json.Marshal(NewPorcelainResult(c))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to marshal near miss:", err)
+		return
+	}
+	nml.lock.Lock()
+	defer nml.lock.Unlock()
+	if _, err = nml.file.Write(append(data, '\n')); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to write near miss to log:", err)
+	}
+}
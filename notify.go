@@ -0,0 +1,262 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// searchTarget returns what the run is looking for, for notification titles.
+func searchTarget() string {
+	if *scoreMode != "" {
+		return "the most " + *scoreMode + " room ID"
+	}
+	return *prefix
+}
+
+// completionMessage returns the title and body for a notification about the end of a run.
+func completionMessage(outcome string, result *Candidate, hashes uint64, dur time.Duration) (title, body string) {
+	switch {
+	case outcome == OutcomeFound:
+		title = "Found " + searchTarget()
+		body = fmt.Sprintf("Found %s after %d hashes in %s", result.RoomID(), hashes, dur.Round(time.Second))
+		if activeScorer() != "" {
+			body += ", which " + describeScore(result)
+		}
+	case result != nil:
+		title = "Near miss for " + searchTarget()
+		body = fmt.Sprintf("Search ended (%s) after %d hashes in %s, best candidate %s %s", outcome, hashes, dur.Round(time.Second), result.RoomID(), describeScore(result))
+	default:
+		title = "No match for " + searchTarget()
+		body = fmt.Sprintf("Search ended (%s) after %d hashes in %s without finding a match", outcome, hashes, dur.Round(time.Second))
+	}
+	return
+}
+
+func postNotification(req *http.Request) error {
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The tags and priorities of the different kinds of ntfy messages.
+// Start and progress messages have a low priority so that they don't make noise on phones.
+const (
+	ntfyTagsStart       = "rocket"
+	ntfyTagsProgress    = "hourglass_flowing_sand"
+	ntfyTagsSuccess     = "tada"
+	ntfyTagsFailure     = "hourglass"
+	ntfyPriorityLow     = "low"
+	ntfyPriorityDefault = "default"
+	ntfyPriorityHigh    = "high"
+)
+
+// sendNtfy publishes a message to an ntfy topic URL. NTFY_TOKEN is used as an access token if set.
+func sendNtfy(topicURL, title, body, tags, priority string) error {
+	req, err := http.NewRequest(http.MethodPost, topicURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", tags)
+	req.Header.Set("Priority", priority)
+	if token := os.Getenv("NTFY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return postNotification(req)
+}
+
+// sendGotify sends a message to a Gotify server using the application token in GOTIFY_TOKEN.
+func sendGotify(serverURL, title, body string, success bool) error {
+	token := os.Getenv("GOTIFY_TOKEN")
+	if token == "" {
+		return fmt.Errorf("GOTIFY_TOKEN is not set")
+	}
+	priority := 5
+	if success {
+		priority = 8
+	}
+	payload, _ := json.Marshal(map[string]any{"title": title, "message": body, "priority": priority})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(serverURL, "/")+"/message", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = url.Values{"token": {token}}.Encode()
+	req.Header.Set("Content-Type", "application/json")
+	return postNotification(req)
+}
+
+// checkMatrixNotifyFlags checks that messages can be sent to the --matrix-room, so that it isn't only found out
+// when the search ends.
+func checkMatrixNotifyFlags() error {
+	if *matrixRoom == "" {
+		return nil
+	} else if !strings.HasPrefix(*matrixRoom, "!") {
+		return fmt.Errorf("--matrix-room must be a room ID starting with !")
+	} else if *homeserverURL == "" {
+		return fmt.Errorf("--matrix-room requires --homeserver")
+	}
+	_, err := newMatrixClient()
+	return err
+}
+
+// sendMatrixMessage sends a notice with the title in bold to the room given with --matrix-room.
+func sendMatrixMessage(title, body string) error {
+	cli, err := newMatrixClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyClient.Timeout)
+	defer cancel()
+	_, err = cli.SendMessageEvent(ctx, id.RoomID(*matrixRoom), event.EventMessage, &event.MessageEventContent{
+		MsgType:       event.MsgNotice,
+		Body:          title + "\n" + body,
+		Format:        event.FormatHTML,
+		FormattedBody: fmt.Sprintf("<strong>%s</strong><br>%s", html.EscapeString(title), html.EscapeString(body)),
+	})
+	return err
+}
+
+// progressMessage returns the title and body for a notification about the progress of a run.
+func progressMessage(start time.Time, workers []*Worker, probability float64) (title, body string) {
+	hashes := totalHashes(workers)
+	dur := time.Since(start)
+	title = "Still searching for " + searchTarget()
+	body = fmt.Sprintf("Checked %d hashes in %s (%.0f hashes/s)", hashes, dur.Round(time.Second), float64(hashes)/dur.Seconds())
+	// With --score, nothing is ever a match, so there's no chance to report.
+	if probability > 0 {
+		chance := -math.Expm1(float64(hashes) * math.Log1p(-probability))
+		body += fmt.Sprintf(", %.1f%% chance of a match by now", chance*100)
+	}
+	if best := bestCandidate(workers); best != nil {
+		body += fmt.Sprintf(", best candidate %s %s", best.RoomID(), describeScore(best))
+	}
+	return
+}
+
+// notifyStart sends a notification about the start of a run to the --ntfy topic.
+func notifyStart(matcher Matcher) {
+	if *ntfyURL == "" {
+		return
+	}
+	title := "Started searching for " + searchTarget()
+	body := fmt.Sprintf("Searching with %d threads on %s", *threadCount, getHardwareInfo().Hostname)
+	if p := matcher.Probability(); p > 0 {
+		body += fmt.Sprintf(", expecting a match after %.0f hashes", 1/p)
+	} else {
+		body += fmt.Sprintf(" until the time limit of %s", time.Duration(*maxSeconds)*time.Second)
+	}
+	if err := sendNtfy(*ntfyURL, title, body, ntfyTagsStart, ntfyPriorityLow); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to send ntfy notification:", err)
+	}
+}
+
+// startProgressNotifier sends progress notifications to the --ntfy topic and --matrix-room every --progress-interval
+// minutes. The workers function must return a copy of the current workers.
+func startProgressNotifier(start time.Time, matcher Matcher, workers func() []*Worker) {
+	if *progressInterval <= 0 || (*ntfyURL == "" && *matrixRoom == "") {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(*progressInterval * float64(time.Minute)))
+		defer ticker.Stop()
+		for range ticker.C {
+			title, body := progressMessage(start, workers(), matcher.Probability())
+			if *ntfyURL != "" {
+				if err := sendNtfy(*ntfyURL, title, body, ntfyTagsProgress, ntfyPriorityLow); err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, "Failed to send ntfy notification:", err)
+				}
+			}
+			if *matrixRoom != "" {
+				if err := sendMatrixMessage(title, body); err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, "Failed to send progress message to Matrix:", err)
+				}
+			}
+		}
+	}()
+}
+
+// WebhookPayload is the JSON body posted to the --webhook-url when a run ends.
+type WebhookPayload struct {
+	Outcome         string  `json:"outcome"`
+	Title           string  `json:"title"`
+	Message         string  `json:"message"`
+	TotalHashes     uint64  `json:"total_hashes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// The found create event, or the best near-miss if the search ended without a match.
+	Result *PorcelainResult `json:"result,omitempty"`
+}
+
+// sendWebhook posts a WebhookPayload to the given URL.
+func sendWebhook(webhookURL string, payload *WebhookPayload) error {
+	data, _ := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return postNotification(req)
+}
+
+// notifyCompletion sends push notifications about the end of a run to the services configured with --ntfy, --gotify,
+// --webhook-url and --matrix-room.
+func notifyCompletion(outcome string, result *Candidate, hashes uint64, dur time.Duration) {
+	if *ntfyURL == "" && *gotifyURL == "" && *webhookURL == "" && *matrixRoom == "" {
+		return
+	}
+	title, body := completionMessage(outcome, result, hashes, dur)
+	success := outcome == OutcomeFound
+	if *ntfyURL != "" {
+		tags, priority := ntfyTagsFailure, ntfyPriorityDefault
+		if success {
+			tags, priority = ntfyTagsSuccess, ntfyPriorityHigh
+		}
+		if err := sendNtfy(*ntfyURL, title, body, tags, priority); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to send ntfy notification:", err)
+		}
+	}
+	if *gotifyURL != "" {
+		if err := sendGotify(*gotifyURL, title, body, success); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to send Gotify notification:", err)
+		}
+	}
+	if *webhookURL != "" {
+		payload := &WebhookPayload{Outcome: outcome, Title: title, Message: body, TotalHashes: hashes, DurationSeconds: dur.Seconds()}
+		if result != nil {
+			payload.Result = NewPorcelainResult(result)
+		}
+		if err := sendWebhook(*webhookURL, payload); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to send webhook:", err)
+		}
+	}
+	if *matrixRoom != "" {
+		if err := sendMatrixMessage(title, body); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to send result to Matrix:", err)
+		}
+	}
+}
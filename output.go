@@ -0,0 +1,224 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+	"go.mau.fi/util/exgjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+// printResult prints the found create event and the matching /createRoom request body to stdout.
+// In --porcelain mode, a single PorcelainResult is printed instead. The result is also written to the -o
+// and --journal files.
+func printResult(c *Candidate) {
+	if err := auditCandidate(c); err != nil {
+		fatal(ExitBackendFailure, "Audit failed:", err)
+	}
+	formedRoomID := c.RoomID()
+	if c.Cached {
+		_, _ = fmt.Fprintln(os.Stderr, "Found cached result", formedRoomID)
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, "Thread ID", c.ThreadID, "iterated over", c.Hashes, "hashes in", c.Duration.String(), "and found", formedRoomID)
+	}
+	writeOutputFile(c)
+	appendJournal(c)
+	storeDatabaseResult(c)
+	if *porcelain {
+		_ = json.NewEncoder(os.Stdout).Encode(NewPorcelainResult(c))
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Event size:", len(c.PDU), "bytes")
+	switch *outputFormat {
+	case OutputFormatSynapseAdmin:
+		_ = json.NewEncoder(os.Stdout).Encode(createRoomRequest(c))
+		return
+	case OutputFormatCurl:
+		fmt.Println(createRoomCurlCommand(c))
+		return
+	case OutputFormatPDU:
+		fmt.Println(string(c.PDU))
+		return
+	}
+	fmt.Println(string(c.PDU))
+	_ = json.NewEncoder(os.Stdout).Encode(createRoomRequest(c))
+	if *verbose {
+		_ = json.NewEncoder(os.Stdout).Encode(NewHashBreakdown(c.PDU))
+	}
+}
+
+const (
+	OutputFormatDefault      = "default"
+	OutputFormatSynapseAdmin = "synapse-admin"
+	OutputFormatCurl         = "curl"
+	OutputFormatPDU          = "pdu"
+)
+
+// checkOutputFormat validates --output-format. The other formats only contain the /createRoom request
+// or the event, so they can't be combined with --porcelain or -v, which output both.
+func checkOutputFormat() error {
+	switch *outputFormat {
+	case OutputFormatDefault:
+		return nil
+	case OutputFormatSynapseAdmin, OutputFormatCurl, OutputFormatPDU:
+		if *porcelain || *verbose {
+			return fmt.Errorf("--output-format=%s can't be combined with --porcelain or -v", *outputFormat)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q (expected %s, %s, %s or %s)", *outputFormat, OutputFormatDefault, OutputFormatSynapseAdmin, OutputFormatCurl, OutputFormatPDU)
+	}
+}
+
+// createRoomCurlCommand returns a curl command that sends the /createRoom request for the given candidate.
+// The access token is read from MATRIX_ACCESS_TOKEN when the command is run, and the homeserver URL is also
+// left as a variable unless --homeserver is given.
+func createRoomCurlCommand(c *Candidate) string {
+	endpoint := `"$MATRIX_HOMESERVER/_matrix/client/v3/createRoom"`
+	if *homeserverURL != "" {
+		endpoint = shellQuote(strings.TrimSuffix(*homeserverURL, "/") + "/_matrix/client/v3/createRoom")
+	}
+	body := exerrors.Must(json.Marshal(createRoomRequest(c)))
+	return strings.Join([]string{
+		"curl", "-X", http.MethodPost,
+		"-H", `"Authorization: Bearer $MATRIX_ACCESS_TOKEN"`,
+		"-H", shellQuote("Content-Type: application/json"),
+		"--data", shellQuote(string(body)),
+		endpoint,
+	}, " ")
+}
+
+// createRoomRequest returns the /createRoom request body that recreates the create event of the given candidate.
+func createRoomRequest(c *Candidate) map[string]any {
+	createContentJSON := gjson.GetBytes(c.PDU, "content").Raw
+	roomVersion := gjson.Get(createContentJSON, "room_version").Str
+	createContentJSON = exerrors.Must(sjson.Delete(createContentJSON, "room_version"))
+	return map[string]any{
+		namespacedKey("origin_server_ts"): gjson.GetBytes(c.PDU, "origin_server_ts").Int(),
+		namespacedKey("room_id"):          c.RoomID(),
+		"creation_content":                json.RawMessage(createContentJSON),
+		"room_version":                    roomVersion,
+	}
+}
+
+// namespacedKey returns the key with the --output-namespace prefix for non-standard /createRoom request fields.
+func namespacedKey(key string) string {
+	if *outputNamespace == "" {
+		return key
+	}
+	return *outputNamespace + "." + key
+}
+
+// The version of the --porcelain output schema. It's only incremented if existing fields are changed or removed.
+const porcelainSchemaVersion = 1
+
+// PorcelainResult is the only thing written to stdout in --porcelain mode.
+// Fields may be added, but existing ones won't be changed or removed without changing the schema version.
+type PorcelainResult struct {
+	SchemaVersion int             `json:"schema_version"`
+	RoomID        id.RoomID       `json:"room_id"`
+	PDU           json.RawMessage `json:"pdu"`
+	CreateRoom    map[string]any  `json:"create_room"`
+	// The size of the PDU in bytes, which must be at most maxPDUSize.
+	Size int `json:"size"`
+	// Set for near-miss candidates output with --best-effort.
+	MatchedLength int            `json:"matched_length,omitempty"`
+	HashBreakdown *HashBreakdown `json:"hash_breakdown,omitempty"`
+	Stats         *ResultStats   `json:"stats"`
+	Inputs        *ResultInputs  `json:"inputs"`
+}
+
+// ResultStats describes how a result was found.
+type ResultStats struct {
+	ThreadID uint16 `json:"thread_id"`
+	// The number of hashes the thread that found the result had checked, not including other threads.
+	Hashes   uint64  `json:"hashes"`
+	Duration float64 `json:"duration_seconds"`
+	Cached   bool    `json:"cached"`
+}
+
+func newResultStats(c *Candidate) *ResultStats {
+	return &ResultStats{ThreadID: c.ThreadID, Hashes: c.Hashes, Duration: c.Duration.Seconds(), Cached: c.Cached}
+}
+
+// ResultInputs contains the parameters of the search that found a result.
+type ResultInputs struct {
+	Sender      id.UserID `json:"sender"`
+	Prefix      string    `json:"prefix,omitempty"`
+	Timestamp   int64     `json:"timestamp"`
+	RoomVersion string    `json:"room_version,omitempty"`
+}
+
+func newResultInputs(c *Candidate) *ResultInputs {
+	return &ResultInputs{
+		Sender:      c.Sender(),
+		Prefix:      *prefix,
+		Timestamp:   *timestamp,
+		RoomVersion: gjson.Get(*createContent, "room_version").Str,
+	}
+}
+
+func NewPorcelainResult(c *Candidate) *PorcelainResult {
+	pr := &PorcelainResult{
+		SchemaVersion: porcelainSchemaVersion,
+		RoomID:        c.RoomID(),
+		PDU:           c.PDU,
+		CreateRoom:    createRoomRequest(c),
+		Size:          len(c.PDU),
+		MatchedLength: c.MatchedLength,
+		Stats:         newResultStats(c),
+		Inputs:        newResultInputs(c),
+	}
+	if *verbose {
+		pr.HashBreakdown = NewHashBreakdown(c.PDU)
+	}
+	return pr
+}
+
+// HashBreakdown contains the intermediate values used to derive an event ID.
+type HashBreakdown struct {
+	Randomness string `json:"randomness"`
+	// The canonical JSON that was hashed to get the content hash, i.e. the event without the hashes field.
+	ContentHashInput string `json:"content_hash_input"`
+	// Unpadded standard base64 of the sha256 content hash, as found in the hashes field.
+	ContentHash string `json:"content_hash"`
+	// The canonical JSON of the redacted event including the content hash, which is hashed to get the event ID.
+	// For create events, this is the same as the event itself.
+	ReferenceHashInput string `json:"reference_hash_input"`
+	// The encoded sha256 reference hash, i.e. the event ID without the sigil.
+	ReferenceHash string `json:"reference_hash"`
+}
+
+// NewHashBreakdown recomputes the intermediate hash values of a canonical event that includes the content hash.
+// Signatures and unsigned data are not covered by either hash, so they're removed first.
+func NewHashBreakdown(pdu []byte) *HashBreakdown {
+	pdu = canonicaljson.CanonicalJSONAssumeValid(exerrors.Must(sjson.DeleteBytes(exerrors.Must(sjson.DeleteBytes(pdu, "signatures")), "unsigned")))
+	contentInput := canonicaljson.CanonicalJSONAssumeValid(exerrors.Must(sjson.DeleteBytes(pdu, "hashes")))
+	contentHash := sha256.Sum256(contentInput)
+	// Create events are not affected by redaction, so for them the reference hash input is the event as-is.
+	referenceInput := canonicaljson.CanonicalJSONAssumeValid(redactPDU(pdu, redactionRulesOf(pdu)))
+	referenceHash := sha256.Sum256(referenceInput)
+	return &HashBreakdown{
+		Randomness:         gjson.GetBytes(pdu, exgjson.Path(append([]string{"content"}, randomnessPath()...)...)).Str,
+		ContentHashInput:   string(contentInput),
+		ContentHash:        base64.RawStdEncoding.EncodeToString(contentHash[:]),
+		ReferenceHashInput: string(referenceInput),
+		ReferenceHash:      eventIDEncoding.EncodeToString(referenceHash[:]),
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isPattern returns true if the prefix contains wildcard syntax. None of ?, [ or ] can appear
+// in base64url event IDs, so prefixes containing them can't be meant literally.
+func isPattern(prefix string) bool {
+	return strings.ContainsAny(prefix, "?[]")
+}
+
+// charClass is the set of bytes accepted at one position of a pattern.
+type charClass [256]bool
+
+func (cc *charClass) count() (n int) {
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		if cc[eventIDAlphabet[i]] {
+			n++
+		}
+	}
+	return
+}
+
+// maskedByte returns a value and mask that accept exactly this class, if there is one.
+func (cc *charClass) maskedByte() (value, mask byte, ok bool) {
+	var members []byte
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		if cc[eventIDAlphabet[i]] {
+			members = append(members, eventIDAlphabet[i])
+		}
+	}
+	switch {
+	case len(members) == len(eventIDAlphabet):
+		return 0, 0, true
+	case len(members) == 1:
+		return members[0], 0xff, true
+	case len(members) == 2 && members[0]&caseFoldMask == members[1]&caseFoldMask && isASCIILetter(members[0]):
+		return members[0] & caseFoldMask, caseFoldMask, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// PatternMatcher accepts event IDs that start with a pattern of per-position character classes.
+type PatternMatcher struct {
+	classes []*charClass
+	value   []byte
+	mask    []byte
+	// Whether the masked prefix covers all classes exactly, so Match doesn't need to check anything else.
+	exact bool
+}
+
+var _ MaskedPrefixer = (*PatternMatcher)(nil)
+
+// NewPatternMatcher parses a prefix pattern, where ? matches any character and [abc] matches any of
+// the listed characters. Ranges like [a-f] are supported inside brackets; a - at the start or end is literal.
+// If fuzzyGlyphs is set, every position also accepts characters that look like the ones in the pattern.
+func NewPatternMatcher(pattern string, ignoreCase, fuzzyGlyphs bool) (*PatternMatcher, error) {
+	var classes []*charClass
+	for i := 0; i < len(pattern); i++ {
+		cc := &charClass{}
+		switch pattern[i] {
+		case '?':
+			for j := 0; j < len(eventIDAlphabet); j++ {
+				cc[eventIDAlphabet[j]] = true
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end <= 0 {
+				return nil, fmt.Errorf("unterminated or empty character set in %s", pattern)
+			}
+			set := pattern[i+1 : i+1+end]
+			for j := 0; j < len(set); j++ {
+				if j+2 < len(set) && set[j+1] == '-' {
+					if set[j] > set[j+2] {
+						return nil, fmt.Errorf("invalid range %s in %s", set[j:j+3], pattern)
+					}
+					for c := int(set[j]); c <= int(set[j+2]); c++ {
+						cc[c] = true
+					}
+					j += 2
+				} else {
+					cc[set[j]] = true
+				}
+			}
+			i += end + 1
+		case ']':
+			return nil, fmt.Errorf("unexpected ] in %s", pattern)
+		default:
+			cc[pattern[i]] = true
+		}
+		if ignoreCase {
+			for c := 'A'; c <= 'Z'; c++ {
+				if cc[c] || cc[c|0x20] {
+					cc[c], cc[c|0x20] = true, true
+				}
+			}
+		}
+		if fuzzyGlyphs {
+			cc.addHomoglyphs()
+		}
+		if cc.count() == 0 {
+			return nil, fmt.Errorf("character %d of %s can't match anything that appears in room IDs", len(classes)+1, pattern)
+		}
+		classes = append(classes, cc)
+	}
+	pm := &PatternMatcher{classes: classes, exact: len(classes) <= fastCompareLength}
+	for _, cc := range classes {
+		value, mask, ok := cc.maskedByte()
+		if !ok {
+			// The class can't be represented with a mask, so accept anything at this position in the fast compare.
+			pm.exact = false
+		}
+		pm.value = append(pm.value, value)
+		pm.mask = append(pm.mask, mask)
+	}
+	return pm, nil
+}
+
+// Len returns the number of characters the pattern matches.
+func (pm *PatternMatcher) Len() int {
+	return len(pm.classes)
+}
+
+func (pm *PatternMatcher) Match(eventID []byte) bool {
+	if len(eventID) < len(pm.classes) {
+		return false
+	}
+	for i, cc := range pm.classes {
+		if !cc[eventID[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+func (pm *PatternMatcher) Probability() float64 {
+	p := 1.0
+	for _, cc := range pm.classes {
+		p *= float64(cc.count()) / float64(len(eventIDAlphabet))
+	}
+	return p
+}
+
+func (pm *PatternMatcher) MaskedPrefix() (value, mask []byte) {
+	return pm.value, pm.mask
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPatternMatcher(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		ignoreCase bool
+		matches    []string
+		rejects    []string
+		exact      bool
+	}{
+		{"abc", false, []string{"abc", "abcdef"}, []string{"ab", "Abc", "xabc"}, true},
+		{"a?c", false, []string{"abc", "a-c", "a_cd", "aAc"}, []string{"ac", "abd"}, true},
+		{"[ab]c", false, []string{"ac", "bc"}, []string{"cc", "Ac"}, false},
+		{"[a-c]x", false, []string{"ax", "bx", "cx"}, []string{"dx", "Ax"}, false},
+		{"[-a]x", false, []string{"-x", "ax"}, []string{"bx"}, false},
+		{"[a-]x", false, []string{"-x", "ax"}, []string{"bx"}, false},
+		{"[aA]b", false, []string{"ab", "Ab"}, []string{"aB"}, true},
+		{"ab", true, []string{"ab", "AB", "aB", "Ab"}, []string{"ac"}, true},
+		{"[a-b]?", true, []string{"Ax", "b_", "B9"}, []string{"cx", "a"}, false},
+		{"?????????", false, []string{"abcdefghi"}, []string{"abcdefgh"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			pm, err := NewPatternMatcher(test.pattern, test.ignoreCase, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cm := CompileMatcher(pm)
+			for _, id := range test.matches {
+				// Pad the IDs so that the fast compare can read 8 bytes.
+				padded := []byte(id + "________")
+				if !pm.Match([]byte(id)) || !cm.Match(padded) {
+					t.Errorf("expected %s to match", id)
+				}
+			}
+			for _, id := range test.rejects {
+				padded := []byte(id + "!!!!!!!!")
+				if pm.Match([]byte(id)) || cm.Match(padded) {
+					t.Errorf("expected %s not to match", id)
+				}
+			}
+			if pm.exact != test.exact {
+				t.Errorf("expected exact to be %t", test.exact)
+			}
+		})
+	}
+}
+
+func TestPatternMatcherErrors(t *testing.T) {
+	for _, pattern := range []string{"[ab", "[]a", "a]", "[c-a]", "!", "[!#]"} {
+		if _, err := NewPatternMatcher(pattern, false, false); err == nil {
+			t.Errorf("expected an error for %s", pattern)
+		}
+	}
+}
+
+func TestPatternMatcherProbability(t *testing.T) {
+	pm, err := NewPatternMatcher("?[ab]c", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, expected := pm.Probability(), 4.0/64*2.0/64; p != expected {
+		t.Errorf("expected probability %g, got %g", expected, p)
+	}
+}
+
+func TestPatternMatcherFuzzyGlyphs(t *testing.T) {
+	pm, err := NewPatternMatcher("O", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pm.Match([]byte("0")) || !pm.Match([]byte("O")) {
+		t.Error("expected O to also match 0 with fuzzy glyphs")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// Keys that are removed from event templates, as they're either not covered by the hashes or computed by matrix-rig.
+var pduTemplateRemovedKeys = []string{"event_id", "hashes", "signatures", "unsigned"}
+
+// parsePDUTemplate validates an event template for the pdu command and prepares it for newTemplate.
+// If the template doesn't have a timestamp, the one given with -t is used.
+func parsePDUTemplate(data []byte) ([]byte, int64, error) {
+	if !gjson.ValidBytes(data) || !gjson.ParseBytes(data).IsObject() {
+		return nil, 0, fmt.Errorf("the event template must be a JSON object")
+	}
+	data, err := normalizeJSON(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid event template: %w", err)
+	}
+	for _, key := range pduTemplateRemovedKeys {
+		data = exerrors.Must(sjson.DeleteBytes(data, key))
+	}
+	pdu := gjson.ParseBytes(data)
+	eventType := pdu.Get("type")
+	if eventType.Type != gjson.String || eventType.Str == "" {
+		return nil, 0, fmt.Errorf("the event template must have a type")
+	} else if _, _, err := id.UserID(pdu.Get("sender").Str).Parse(); err != nil {
+		return nil, 0, fmt.Errorf("the event template must have a valid sender")
+	} else if !pdu.Get("content").IsObject() {
+		return nil, 0, fmt.Errorf("the event template must have content as an object")
+	} else if !pdu.Get("prev_events").IsArray() || !pdu.Get("auth_events").IsArray() {
+		return nil, 0, fmt.Errorf("the event template must have prev_events and auth_events as lists")
+	} else if depth := pdu.Get("depth"); depth.Type != gjson.Number {
+		return nil, 0, fmt.Errorf("the event template must have a depth")
+	} else if stateKey := pdu.Get("state_key"); stateKey.Exists() && stateKey.Type != gjson.String {
+		return nil, 0, fmt.Errorf("state_key in the event template must be a string")
+	} else if eventType.Str != "m.room.create" && !strings.HasPrefix(pdu.Get("room_id").Str, "!") {
+		return nil, 0, fmt.Errorf("the event template must have the room_id of the room the event is sent to")
+	}
+	ts := pdu.Get("origin_server_ts")
+	if !ts.Exists() {
+		data = exerrors.Must(sjson.SetBytes(data, "origin_server_ts", *timestamp))
+		return data, *timestamp, nil
+	} else if ts.Type != gjson.Number || ts.Raw != fmt.Sprint(ts.Int()) {
+		return nil, 0, fmt.Errorf("origin_server_ts in the event template must be an integer")
+	}
+	return data, ts.Int(), nil
+}
+
+// PDUResult is written to stdout in --porcelain mode when the pdu command finds a match.
+type PDUResult struct {
+	SchemaVersion int             `json:"schema_version"`
+	EventID       id.EventID      `json:"event_id"`
+	PDU           json.RawMessage `json:"pdu"`
+	Size          int             `json:"size"`
+	HashBreakdown *HashBreakdown  `json:"hash_breakdown,omitempty"`
+	Stats         *ResultStats    `json:"stats"`
+}
+
+func printPDUResult(c *Candidate) {
+	if err := auditCandidate(c); err != nil {
+		fatal(ExitBackendFailure, "Audit failed:", err)
+	}
+	eventID := id.EventID("$" + c.EventID)
+	_, _ = fmt.Fprintln(os.Stderr, "Thread ID", c.ThreadID, "iterated over", c.Hashes, "hashes in", c.Duration.String(), "and found", eventID)
+	var breakdown *HashBreakdown
+	if *verbose {
+		breakdown = NewHashBreakdown(c.PDU)
+	}
+	if *porcelain {
+		_ = json.NewEncoder(os.Stdout).Encode(&PDUResult{
+			SchemaVersion: porcelainSchemaVersion,
+			EventID:       eventID,
+			PDU:           c.PDU,
+			Size:          len(c.PDU),
+			HashBreakdown: breakdown,
+			Stats:         newResultStats(c),
+		})
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Event size:", len(c.PDU), "bytes")
+	fmt.Println(string(c.PDU))
+	fmt.Println(eventID)
+	if breakdown != nil {
+		_ = json.NewEncoder(os.Stdout).Encode(breakdown)
+	}
+}
+
+// runPDU bruteforces a vanity event ID for the event template read from stdin.
+func runPDU() {
+	version := *roomVersion
+	if version == "" {
+		version = defaultPDURoomVersion
+	}
+	info, ok := getRoomVersion(version)
+	if !ok {
+		fatalf(ExitInvalidInput, "Unknown room version %q (use --unstable-room-versions to try it as if it was room version 12)", version)
+	} else if !info.HashEventIDs {
+		fatalf(ExitInvalidInput, "Event IDs in room version %s aren't URL-safe reference hashes, so they can't be bruteforced (room version 4 or newer is required)", version)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to read event template from stdin:", err)
+	}
+	pdu, ts, err := parsePDUTemplate(data)
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err = checkTemplateFlags(ts, gjson.GetBytes(pdu, "content").Raw); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	signingKey, err := loadSelectedSigningKey()
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to load signing key:", err)
+	} else if err = checkFederationFlags(signingKey); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	tpl := newTemplate(pdu, ts)
+	if err = checkEventSize(tpl, signingKey); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if *dryRun {
+		printDryRun([]*Template{tpl}, matcher, signingKey)
+		os.Exit(ExitFound)
+	} else if tpl.RandomnessLength == 0 {
+		warnKeyspace([]*Template{tpl}, matcher)
+	}
+	var foundLock sync.Mutex
+	workers := newWorkers([]*Template{tpl}, matcher, func(c *Candidate) bool {
+		foundLock.Lock()
+		if signingKey != nil {
+			c.PDU = signPDU(c.PDU, c.Sender().Homeserver(), signingKey)
+		}
+		printPDUResult(c)
+		federatePDU(c, signingKey)
+		os.Exit(ExitFound)
+		return false
+	})
+	var wg sync.WaitGroup
+	startWorkers(workers, &wg)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var deadline <-chan time.Time
+	if *maxSeconds >= 0 {
+		deadline = time.After(time.Duration(*maxSeconds) * time.Second)
+	}
+	select {
+	case <-done:
+		foundLock.Lock()
+		exitWithError(ExitNotFound, "No solution found in the search space")
+	case <-deadline:
+		foundLock.Lock()
+		exitWithError(ExitNotFound, fmt.Sprint("No solution found in ", time.Duration(*maxSeconds)*time.Second))
+	}
+}
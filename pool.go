@@ -0,0 +1,345 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/exhttp"
+
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// addToPool adds a pre-mined create event to the pool. It returns false if the room ID was already in the pool.
+func addToPool(c *Candidate) (bool, error) {
+	res, err := database.Exec(context.Background(),
+		"INSERT INTO pool (room_id, sender, pdu, added_at) VALUES ($1, $2, $3, $4) ON CONFLICT (room_id) DO NOTHING",
+		c.RoomID(), c.Sender(), string(c.PDU), time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return false, err
+	}
+	added, err := res.RowsAffected()
+	return added > 0, err
+}
+
+// claimFromPool marks the oldest unclaimed create event for the given sender whose room ID starts with the prefix as
+// claimed and returns it. If there's no such event, nil is returned.
+func claimFromPool(sender id.UserID, prefix string) (*Candidate, error) {
+	var roomID, pdu string
+	roomIDPrefix := *roomIDSigil + prefix
+	err := database.QueryRow(context.Background(), `
+		UPDATE pool SET claimed_at=$1 WHERE room_id=(
+			SELECT room_id FROM pool WHERE claimed_at IS NULL AND sender=$2 AND substr(room_id, 1, $3)=$4 ORDER BY added_at LIMIT 1
+		) RETURNING room_id, pdu
+	`, time.Now().UnixMilli(), sender, len(roomIDPrefix), roomIDPrefix).Scan(&roomID, &pdu)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &Candidate{EventID: strings.TrimPrefix(roomID, *roomIDSigil), PDU: []byte(pdu), Cached: true}, nil
+}
+
+// importToPool reads create events or --porcelain results from the reader, one per line, verifies that they're
+// valid and adds them to the pool.
+func importToPool(reader io.Reader) (added, skipped int, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 4*maxPDUSize)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pdu, claimedRoomID, err := parseCreateEventInput([]byte(line))
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping line %d: %v\n", i, err)
+			skipped++
+			continue
+		}
+		breakdown := NewHashBreakdown(pdu)
+		c := &Candidate{EventID: breakdown.ReferenceHash, PDU: pdu}
+		if gjson.GetBytes(pdu, "hashes.sha256").Str != breakdown.ContentHash {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping line %d: the content hash is invalid\n", i)
+			skipped++
+			continue
+		} else if claimedRoomID != "" && claimedRoomID != c.RoomID() {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping line %d: the result claims the room ID %s, but the create event derives %s\n", i, claimedRoomID, c.RoomID())
+			skipped++
+			continue
+		}
+		ok, err := addToPool(c)
+		if err != nil {
+			return added, skipped, fmt.Errorf("failed to add line %d to pool: %w", i, err)
+		} else if ok {
+			added++
+		} else {
+			skipped++
+		}
+	}
+	return added, skipped, scanner.Err()
+}
+
+// PoolClaim is the response to a successful claim from the pool server.
+type PoolClaim struct {
+	RoomID     id.RoomID       `json:"room_id"`
+	PDU        json.RawMessage `json:"pdu"`
+	CreateRoom map[string]any  `json:"create_room"`
+}
+
+type poolClaimRequest struct {
+	Prefix string    `json:"prefix"`
+	Sender id.UserID `json:"sender"`
+}
+
+// PoolStats is the response to the pool stats endpoint.
+type PoolStats struct {
+	Available int `json:"available"`
+	Claimed   int `json:"claimed"`
+}
+
+// requirePoolToken checks that requests include the given access token.
+func requirePoolToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			mautrix.MMissingToken.WithMessage("Missing access token").Write(w)
+		} else if subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+			mautrix.MUnknownToken.WithMessage("Invalid access token").Write(w)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func handlePoolClaim(w http.ResponseWriter, r *http.Request) {
+	var req poolClaimRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		mautrix.MNotJSON.WithMessage("Failed to parse request body: %v", err).Write(w)
+		return
+	} else if _, _, err = req.Sender.Parse(); err != nil {
+		mautrix.MInvalidParam.WithMessage("Invalid sender %q", req.Sender).Write(w)
+		return
+	}
+	c, err := claimFromPool(req.Sender, req.Prefix)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to claim from pool:", err)
+		mautrix.MUnknown.WithMessage("Failed to claim from pool").Write(w)
+		return
+	} else if c == nil {
+		mautrix.MNotFound.WithMessage("No room IDs starting with %q available for %s", req.Prefix, req.Sender).Write(w)
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Claimed", c.RoomID(), "for", req.Sender)
+	exhttp.WriteJSONResponse(w, http.StatusOK, &PoolClaim{RoomID: c.RoomID(), PDU: c.PDU, CreateRoom: createRoomRequest(c)})
+}
+
+func handlePoolStats(w http.ResponseWriter, r *http.Request) {
+	var stats PoolStats
+	err := database.QueryRow(context.Background(),
+		"SELECT COUNT(*) FILTER (WHERE claimed_at IS NULL), COUNT(*) FILTER (WHERE claimed_at IS NOT NULL) FROM pool",
+	).Scan(&stats.Available, &stats.Claimed)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to get pool stats:", err)
+		mautrix.MUnknown.WithMessage("Failed to get pool stats").Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &stats)
+}
+
+// runPool manages the pool of pre-mined create events in the --database.
+func runPool() {
+	if *databasePath == "" {
+		fatalf(ExitInvalidInput, "The pool command requires --database")
+	} else if err := openDatabase(); err != nil {
+		fatal(ExitBackendFailure, err)
+	}
+	switch flag.Arg(1) {
+	case "import":
+		added, skipped, err := importToPool(os.Stdin)
+		_, _ = fmt.Fprintln(os.Stderr, "Added", added, "create events to the pool, skipped", skipped)
+		if err != nil {
+			fatal(ExitBackendFailure, "Failed to import create events:", err)
+		}
+	case "mine":
+		runPoolMiner()
+	case "serve":
+		token := os.Getenv("MATRIX_RIG_POOL_TOKEN")
+		if token == "" {
+			fatalf(ExitInvalidInput, "The pool server requires $MATRIX_RIG_POOL_TOKEN to be set")
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /_matrix-rig/v1/claim", handlePoolClaim)
+		mux.HandleFunc("GET /_matrix-rig/v1/stats", handlePoolStats)
+		server := &http.Server{
+			Addr:              *listenAddress,
+			Handler:           requirePoolToken(token, mux),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		_, _ = fmt.Fprintln(os.Stderr, "Serving pool on", *listenAddress)
+		if err := server.ListenAndServe(); err != nil {
+			fatal(ExitBackendFailure, "Failed to serve pool:", err)
+		}
+	default:
+		fatalf(ExitUsage, "Usage: matrix-rig pool <import|mine|serve> --database=file [--listen=address]")
+	}
+}
+
+// How long the pool miner waits before checking again when it's outside --idle-hours or the pool is full.
+const poolWaitInterval = time.Minute
+
+// parseIdleHours parses an --idle-hours value like 22-7 into the start and end hours in local time.
+func parseIdleHours(value string) (start, end int, err error) {
+	startStr, endStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("--idle-hours must be in the format start-end, like 22-7")
+	}
+	start, err = strconv.Atoi(startStr)
+	if err == nil {
+		end, err = strconv.Atoi(endStr)
+	}
+	if err != nil || start < 0 || start > 23 || end < 0 || end > 24 {
+		return 0, 0, fmt.Errorf("--idle-hours must be two hours between 0 and 24, like 22-7")
+	}
+	return start, end, nil
+}
+
+// inIdleHours checks whether the time is within the --idle-hours, which may wrap around midnight.
+func inIdleHours(t time.Time, start, end int) bool {
+	hour := t.Hour()
+	if start <= end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+type poolTarget struct {
+	sender id.UserID
+	prefix string
+}
+
+// countAvailable returns the number of unclaimed create events in the pool for the target.
+// If the target is nil, all unclaimed create events are counted.
+func countAvailable(target *poolTarget) (count int, err error) {
+	if target == nil {
+		err = database.QueryRow(context.Background(), "SELECT COUNT(*) FROM pool WHERE claimed_at IS NULL").Scan(&count)
+	} else {
+		roomIDPrefix := *roomIDSigil + target.prefix
+		err = database.QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM pool WHERE claimed_at IS NULL AND sender=$1 AND substr(room_id, 1, $2)=$3",
+			target.sender, len(roomIDPrefix), roomIDPrefix,
+		).Scan(&count)
+	}
+	return
+}
+
+// nextPoolTarget picks the first target from the offset onwards that has less than --pool-per-prefix unclaimed
+// create events. The offset is incremented after each attempt, so that hard prefixes don't block easier ones.
+// It returns -1 if every target is full.
+func nextPoolTarget(targets []poolTarget, offset int) (int, error) {
+	for i := range targets {
+		index := (offset + i) % len(targets)
+		if count, err := countAvailable(&targets[index]); err != nil {
+			return -1, err
+		} else if count < *poolPerPrefix {
+			return index, nil
+		}
+	}
+	return -1, nil
+}
+
+// runPoolMiner mines create events for the prefixes given with -p continuously and adds them to the pool, until the
+// pool has --pool-per-prefix create events for each prefix and sender or --pool-quota create events in total.
+func runPoolMiner() {
+	var idleStart, idleEnd int
+	var err error
+	if *idleHours != "" {
+		if idleStart, idleEnd, err = parseIdleHours(*idleHours); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	prefixes := targetPrefixes()
+	if len(prefixes) == 0 {
+		fatalf(ExitInvalidInput, "The pool miner requires prefixes to mine with -p")
+	} else if *maxSeconds < 0 {
+		fatalf(ExitInvalidInput, "The pool miner requires a time limit for each prefix with -m")
+	} else if *poolPerPrefix < 1 || *poolQuota < 1 {
+		fatalf(ExitInvalidInput, "--pool-per-prefix and --pool-quota must be at least 1")
+	}
+	var targets []poolTarget
+	for _, sender := range senders() {
+		for _, prefix := range prefixes {
+			targets = append(targets, poolTarget{sender: sender, prefix: prefix})
+		}
+	}
+	defaults := &pipelineDefaults{
+		prefixArgs:    *prefixArgs,
+		sender:        id.UserID(*creator),
+		contentSource: createContentSource,
+	}
+	waiting := ""
+	wait := func(reason string) {
+		if waiting != reason {
+			_, _ = fmt.Fprintln(os.Stderr, reason)
+			waiting = reason
+		}
+		time.Sleep(poolWaitInterval)
+	}
+	for offset := 0; ; offset++ {
+		if *idleHours != "" && !inIdleHours(time.Now(), idleStart, idleEnd) {
+			wait(fmt.Sprintf("Outside idle hours %s, waiting", *idleHours))
+			continue
+		}
+		total, err := countAvailable(nil)
+		if err != nil {
+			fatal(ExitBackendFailure, "Failed to count create events in pool:", err)
+		} else if total >= *poolQuota {
+			wait(fmt.Sprintf("Pool has %d create events, which reaches the quota, waiting", total))
+			continue
+		}
+		index, err := nextPoolTarget(targets, offset)
+		if err != nil {
+			fatal(ExitBackendFailure, "Failed to count create events in pool:", err)
+		} else if index == -1 {
+			wait("Pool has enough create events for every prefix, waiting")
+			continue
+		}
+		waiting = ""
+		target := targets[index]
+		job := &pipelineJob{Prefix: target.prefix, Sender: target.sender, Timestamp: time.Now().UnixMilli()}
+		c, jobErr := runPipelineJob(job, defaults, nil)
+		if jobErr != nil && jobErr.ExitCode == ExitNotFound {
+			_, _ = fmt.Fprintln(os.Stderr, "No create event found for", target.prefix, "in", time.Duration(*maxSeconds)*time.Second, "- rotating")
+			continue
+		} else if jobErr != nil {
+			fatalf(jobErr.ExitCode, "Failed to mine for %s: %s", target.prefix, jobErr.Message)
+		}
+		if added, err := addToPool(c); err != nil {
+			fatal(ExitBackendFailure, "Failed to add create event to pool:", err)
+		} else if added {
+			_, _ = fmt.Fprintln(os.Stderr, "Added", c.RoomID(), "for", target.sender, "to the pool after", c.Hashes, "hashes in", c.Duration.String())
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, c.RoomID(), "is already in the pool")
+		}
+	}
+}
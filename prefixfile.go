@@ -0,0 +1,192 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const prefixFilePollInterval = 2 * time.Second
+
+// AnyPrefixMatcher accepts event IDs that start with any of the given prefixes.
+type AnyPrefixMatcher struct {
+	// Prefixes with a literal first character are grouped by it, so that only a few need to be checked per hash.
+	byFirst [256][]*CompiledMatcher
+	// Prefixes that don't start with a literal character.
+	rest  []*CompiledMatcher
+	value []byte
+	mask  []byte
+	prob  float64
+}
+
+var _ MaskedPrefixer = (*AnyPrefixMatcher)(nil)
+
+func NewAnyPrefixMatcher(prefixes []Matcher) *AnyPrefixMatcher {
+	apm := &AnyPrefixMatcher{}
+	for i, pm := range prefixes {
+		apm.prob += pm.Probability()
+		value, mask := maskedPrefixOf(pm)
+		if len(mask) > 0 && mask[0] == 0xff {
+			apm.byFirst[value[0]] = append(apm.byFirst[value[0]], CompileMatcher(pm))
+		} else {
+			apm.rest = append(apm.rest, CompileMatcher(pm))
+		}
+		if i == 0 {
+			apm.value, apm.mask = value, mask
+			continue
+		}
+		// Only keep the part of the masked prefix that is shared by all the prefixes.
+		n := min(len(apm.value), len(value))
+		for j := 0; j < n; j++ {
+			if apm.value[j] != value[j] || apm.mask[j] != mask[j] {
+				n = j
+				break
+			}
+		}
+		apm.value, apm.mask = apm.value[:n], apm.mask[:n]
+	}
+	return apm
+}
+
+func (apm *AnyPrefixMatcher) Match(eventID []byte) bool {
+	for _, pm := range apm.byFirst[eventID[0]] {
+		if pm.Match(eventID) {
+			return true
+		}
+	}
+	for _, pm := range apm.rest {
+		if pm.Match(eventID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (apm *AnyPrefixMatcher) Probability() float64 {
+	return min(apm.prob, 1)
+}
+
+func (apm *AnyPrefixMatcher) MaskedPrefix() (value, mask []byte) {
+	return apm.value, apm.mask
+}
+
+// newAlternativesMatcher returns a matcher that accepts event IDs starting with any of the given prefixes
+// or accepted by any of the extra matchers, or nil if there are none. Large sets of literal prefixes
+// are put in an Aho–Corasick trie, while wildcard patterns are checked separately.
+func newAlternativesMatcher(prefixStrings []string, extra []Matcher) (Matcher, error) {
+	prefixes, err := parsePrefixes(prefixStrings)
+	if err != nil {
+		return nil, err
+	}
+	var literals []string
+	var others []Matcher
+	for i, pm := range prefixes {
+		switch pm.(type) {
+		case PrefixMatcher, FoldPrefixMatcher:
+			literals = append(literals, prefixStrings[i])
+		default:
+			others = append(others, pm)
+		}
+	}
+	if len(literals) >= acMinPatterns {
+		prefixes = append(others, NewAhoCorasickMatcher(literals, *ignoreCase, false))
+	}
+	prefixes = append(prefixes, extra...)
+	switch len(prefixes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return prefixes[0], nil
+	default:
+		return NewAnyPrefixMatcher(prefixes), nil
+	}
+}
+
+// ReloadableMatcher is a matcher that can be swapped out while workers are using it.
+type ReloadableMatcher struct {
+	current atomic.Pointer[CompiledMatcher]
+}
+
+func (rm *ReloadableMatcher) Match(eventID []byte) bool {
+	return rm.current.Load().Match(eventID)
+}
+
+func (rm *ReloadableMatcher) Probability() float64 {
+	return rm.current.Load().Probability()
+}
+
+// readPrefixFile reads a list of prefixes from a file with one prefix per line. Empty lines and lines starting with # are ignored.
+func readPrefixFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err = parsePrefix(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		prefixes = append(prefixes, line)
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no prefixes found in %s", path)
+	}
+	return prefixes, nil
+}
+
+// newPrefixFileMatcher creates a matcher for the prefixes in the given file (plus any extra ones)
+// and keeps watching the file for changes in the background.
+func newPrefixFileMatcher(path string, extraPrefixes []string, extra []Matcher) (*ReloadableMatcher, error) {
+	load := func() (int, *CompiledMatcher, error) {
+		prefixes, err := readPrefixFile(path)
+		if err != nil {
+			return 0, nil, err
+		}
+		m, err := newAlternativesMatcher(append(prefixes, extraPrefixes...), extra)
+		if err != nil {
+			return 0, nil, err
+		}
+		return len(prefixes), CompileMatcher(m), nil
+	}
+	_, initial, err := load()
+	if err != nil {
+		return nil, err
+	}
+	rm := &ReloadableMatcher{}
+	rm.current.Store(initial)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		lastModTime, lastSize := stat.ModTime(), stat.Size()
+		for {
+			time.Sleep(prefixFilePollInterval)
+			stat, err := os.Stat(path)
+			if err != nil || (stat.ModTime().Equal(lastModTime) && stat.Size() == lastSize) {
+				continue
+			}
+			lastModTime, lastSize = stat.ModTime(), stat.Size()
+			count, reloaded, err := load()
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "Failed to reload prefix file, keeping old prefixes:", err)
+				continue
+			}
+			rm.current.Store(reloaded)
+			_, _ = fmt.Fprintln(os.Stderr, "Reloaded", count, "prefixes from", path)
+		}
+	}()
+	return rm, nil
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// tuneWorkerThread sets the nice value and CPU affinity of the calling OS thread.
+func tuneWorkerThread(nice, cpu int) error {
+	if nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, unix.Gettid(), nice); err != nil {
+			return err
+		}
+	}
+	if cpu >= 0 {
+		var set unix.CPUSet
+		set.Set(cpu)
+		return unix.SchedSetaffinity(0, &set)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func tuneWorkerThread(nice, cpu int) error {
+	if nice != 0 || cpu >= 0 {
+		return errors.New("setting worker priority and affinity is only supported on Linux")
+	}
+	return nil
+}
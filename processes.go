@@ -0,0 +1,228 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// workerSpec is sent to the stdin of child worker processes to tell them what to do.
+// The matcher is built from the command-line flags, which are passed to the child as-is.
+type workerSpec struct {
+	ThreadID         uint16    `json:"thread_id"`
+	StartCounter     uint64    `json:"start_counter"`
+	EndCounter       uint64    `json:"end_counter,omitempty"`
+	ChunkSize        uint32    `json:"chunk_size"`
+	Template         *Template `json:"template"`
+	BestEffortTarget []byte    `json:"best_effort_target,omitempty"`
+	Scorer           string    `json:"scorer,omitempty"`
+	TopCandidates    int       `json:"top_candidates,omitempty"`
+	// If set, near misses of at least this length are sent to the parent as they're found.
+	NearMissLength int `json:"near_miss_length,omitempty"`
+	Nice           int `json:"nice,omitempty"`
+	Affinity       int `json:"affinity"`
+}
+
+// workerMessage is written to stdout by child worker processes, one per line.
+type workerMessage struct {
+	Hashes  uint64         `json:"hashes"`
+	Counter *workerCounter `json:"counter,omitempty"`
+	Found   *Candidate     `json:"found,omitempty"`
+	Best    *Candidate     `json:"best,omitempty"`
+	Top     []*Candidate   `json:"top,omitempty"`
+
+	NearMiss *Candidate `json:"near_miss,omitempty"`
+}
+
+// workerCounter is sent in progress messages, so that the parent knows where to continue the range of the child.
+type workerCounter struct {
+	Next              uint64 `json:"next"`
+	TimestampPosition int64  `json:"timestamp_position"`
+}
+
+const workerProgressInterval = 500 * time.Millisecond
+
+// runProcess runs the worker in a child process. The child is stopped by closing its stdin.
+func (w *Worker) runProcess() {
+	exe, err := os.Executable()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to find executable for worker process:", err)
+		return
+	}
+	// The default mode doesn't have any positional arguments, so all the original arguments are flags.
+	cmd := exec.Command(exe, append([]string{"worker"}, os.Args[1:]...)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to create stdin pipe for worker process for thread ID", w.ThreadID, "-", err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = stdin.Close()
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to create stdout pipe for worker process for thread ID", w.ThreadID, "-", err)
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to start worker process for thread ID", w.ThreadID, "-", err)
+		return
+	}
+	err = json.NewEncoder(stdin).Encode(&workerSpec{
+		ThreadID:         w.ThreadID,
+		StartCounter:     w.StartCounter,
+		EndCounter:       w.EndCounter,
+		ChunkSize:        w.ChunkSize,
+		Template:         w.Template,
+		BestEffortTarget: w.BestEffortTarget,
+		Scorer:           w.Scorer,
+		TopCandidates:    w.TopCandidates,
+		NearMissLength:   w.NearMissLength,
+		Nice:             *processNice,
+		Affinity:         w.Affinity,
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to send spec to worker process for thread ID", w.ThreadID, "-", err)
+	}
+	var closeOnce sync.Once
+	closeStdin := func() {
+		closeOnce.Do(func() {
+			_ = stdin.Close()
+		})
+	}
+	exited := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(workerProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if w.stop.Load() {
+					closeStdin()
+					return
+				}
+			case <-exited:
+				return
+			}
+		}
+	}()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var msg workerMessage
+		if err = json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Invalid message from worker process for thread ID", w.ThreadID, "-", err)
+			continue
+		}
+		w.hashes.Store(msg.Hashes)
+		if msg.Counter != nil {
+			w.nextCounter.Store(msg.Counter.Next)
+			if msg.Counter.TimestampPosition != w.Template.TimestampPosition {
+				w.Template.setTimestampPosition(msg.Counter.TimestampPosition)
+			}
+		}
+		if msg.Best != nil {
+			w.best.Store(msg.Best)
+		}
+		if msg.Top != nil {
+			w.top.Store(&msg.Top)
+		}
+		if msg.NearMiss != nil && w.OnNearMiss != nil {
+			w.OnNearMiss(msg.NearMiss)
+		}
+		if msg.Found != nil && !w.OnFound(msg.Found) {
+			closeStdin()
+		}
+	}
+	close(exited)
+	closeStdin()
+	if err = cmd.Wait(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Worker process for thread ID", w.ThreadID, "exited with error:", err)
+	}
+}
+
+// runWorker is the entrypoint of child worker processes.
+func runWorker() {
+	var spec workerSpec
+	if err := json.NewDecoder(os.Stdin).Decode(&spec); err != nil {
+		fatal(ExitBackendFailure, "Failed to read worker spec:", err)
+	}
+	var outLock sync.Mutex
+	out := json.NewEncoder(os.Stdout)
+	send := func(msg *workerMessage) {
+		outLock.Lock()
+		_ = out.Encode(msg)
+		outLock.Unlock()
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	w := newWorker(spec.ThreadID, spec.Template, matcher, func(c *Candidate) bool {
+		send(&workerMessage{Hashes: c.Hashes, Found: c})
+		return true
+	})
+	w.ChunkSize = spec.ChunkSize
+	w.StartCounter = spec.StartCounter
+	w.EndCounter = spec.EndCounter
+	w.BestEffortTarget = spec.BestEffortTarget
+	w.Scorer = spec.Scorer
+	w.TopCandidates = spec.TopCandidates
+	if spec.NearMissLength > 0 {
+		w.NearMissLength = spec.NearMissLength
+		w.OnNearMiss = func(c *Candidate) {
+			send(&workerMessage{Hashes: c.Hashes, NearMiss: c})
+		}
+	}
+	go func() {
+		// The parent closes stdin to stop the worker (or by exiting).
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		w.Stop()
+	}()
+	go func() {
+		// Only the hashing thread is tuned, as that's the one that matters.
+		runtime.LockOSThread()
+		if err := tuneWorkerThread(spec.Nice, spec.Affinity); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to set priority or affinity of thread ID", spec.ThreadID, "-", err)
+		}
+		w.Run(func() {})
+	}()
+	ticker := time.NewTicker(workerProgressInterval)
+	var lastBest *Candidate
+	var lastTop *[]*Candidate
+	sendProgress := func() {
+		msg := &workerMessage{
+			Hashes:  w.Hashes(),
+			Counter: &workerCounter{Next: w.NextCounter(), TimestampPosition: w.TimestampPosition()},
+		}
+		if best := w.Best(); best != lastBest {
+			msg.Best = best
+			lastBest = best
+		}
+		if top := w.top.Load(); top != lastTop {
+			msg.Top = *top
+			lastTop = top
+		}
+		send(msg)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			sendProgress()
+		case <-w.Done():
+			sendProgress()
+			os.Exit(ExitFound)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+// The longest run of consonants or vowels that's still considered pronounceable.
+const maxPronounceableRun = 2
+
+// pronounceableKinds classifies bytes as vowels (1), consonants (2) or unpronounceable (0), ignoring case.
+var pronounceableKinds = func() (kinds [256]byte) {
+	for c := 'a'; c <= 'z'; c++ {
+		kind := byte(2)
+		switch c {
+		case 'a', 'e', 'i', 'o', 'u', 'y':
+			kind = 1
+		}
+		kinds[c], kinds[c&caseFoldMask] = kind, kind
+	}
+	return
+}()
+
+// pronounceableLength returns how many leading characters of the event ID (up to limit) look pronounceable,
+// i.e. are letters where no more than two consonants or two vowels follow each other.
+func pronounceableLength(eventID []byte, limit int) int {
+	var lastKind byte
+	var run int
+	for i, char := range eventID[:min(limit, len(eventID))] {
+		kind := pronounceableKinds[char]
+		if kind == 0 {
+			return i
+		} else if kind == lastKind {
+			if run++; run > maxPronounceableRun {
+				return i
+			}
+		} else {
+			lastKind, run = kind, 1
+		}
+	}
+	return min(limit, len(eventID))
+}
+
+// PronounceableMatcher accepts event IDs whose first characters look pronounceable.
+type PronounceableMatcher int
+
+func (pm PronounceableMatcher) Match(eventID []byte) bool {
+	return pronounceableLength(eventID, int(pm)) >= int(pm)
+}
+
+// Probability computes the exact chance by tracking the probability of each (kind, run length) state per character.
+func (pm PronounceableMatcher) Probability() float64 {
+	var vowels, consonants int
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		switch pronounceableKinds[eventIDAlphabet[i]] {
+		case 1:
+			vowels++
+		case 2:
+			consonants++
+		}
+	}
+	pVowel := float64(vowels) / float64(len(eventIDAlphabet))
+	pConsonant := float64(consonants) / float64(len(eventIDAlphabet))
+	// state[kind][run-1] is the chance of the prefix so far being valid and ending with a run of that kind.
+	var state [2][maxPronounceableRun]float64
+	state[0][0], state[1][0] = pVowel, pConsonant
+	for i := 1; i < int(pm); i++ {
+		var next [2][maxPronounceableRun]float64
+		for kind := range state {
+			for run, p := range state[kind] {
+				if run+1 < maxPronounceableRun {
+					next[kind][run+1] += p * []float64{pVowel, pConsonant}[kind]
+				}
+				next[1-kind][0] += p * []float64{pConsonant, pVowel}[kind]
+			}
+		}
+		state = next
+	}
+	var total float64
+	for kind := range state {
+		for _, p := range state[kind] {
+			total += p
+		}
+	}
+	return total
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// raceMatcher accepts event IDs matching any of the prefixes that haven't been found yet.
+// Found prefixes are removed from the active matcher entirely, so all threads only spend time
+// on the unsolved ones, and the literal prefix of the fast path grows when possible.
+type raceMatcher struct {
+	ReloadableMatcher
+	names    []string
+	prefixes []Matcher
+	found    []atomic.Bool
+}
+
+func newRaceMatcher(names []string) (*raceMatcher, error) {
+	prefixes, err := parsePrefixes(names)
+	if err != nil {
+		return nil, err
+	}
+	rm := &raceMatcher{
+		names:    names,
+		prefixes: prefixes,
+		found:    make([]atomic.Bool, len(prefixes)),
+	}
+	rm.current.Store(CompileMatcher(NewAnyPrefixMatcher(prefixes)))
+	return rm, nil
+}
+
+// markFound marks the prefix at the given index as found and swaps in a matcher for the remaining prefixes.
+// Returns false if the prefix was already found. Must not be called concurrently.
+func (rm *raceMatcher) markFound(i int) bool {
+	if rm.found[i].Swap(true) {
+		return false
+	}
+	var remaining []Matcher
+	for j, pm := range rm.prefixes {
+		if !rm.found[j].Load() {
+			remaining = append(remaining, pm)
+		}
+	}
+	rm.current.Store(CompileMatcher(NewAnyPrefixMatcher(remaining)))
+	return true
+}
+
+type raceResult struct {
+	Prefix    string
+	Expected  float64
+	Hashes    uint64
+	Elapsed   time.Duration
+	Candidate *Candidate
+}
+
+func runRace() {
+	prefixes := flag.Args()[1:]
+	prefixes = append(targetPrefixes(), prefixes...)
+	creatorUserID := id.UserID(*creator)
+	if _, _, err := creatorUserID.Parse(); err != nil {
+		fatalf(ExitInvalidInput, "Invalid user ID: %s", *creator)
+	} else if len(senders()) > 1 {
+		fatalf(ExitInvalidInput, "Race mode only supports a single user ID")
+	} else if !json.Valid([]byte(*createContent)) {
+		fatalf(ExitInvalidInput, "Invalid create event content")
+	} else if len(prefixes) < 2 {
+		fatalf(ExitInvalidInput, "Race mode needs at least two prefixes")
+	} else if int(*threadIndexStart)+int(*threadCount) > math.MaxUint16 {
+		fatalf(ExitInvalidInput, "Thread index %d + %d exceeds uint16 limit", *threadIndexStart, *threadCount)
+	} else if err := checkRoomVersion(*createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkTemplateFlags(*timestamp, *createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	rm, err := newRaceMatcher(prefixes)
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+
+	tpl := NewCreateTemplate(creatorUserID, *timestamp, json.RawMessage(*createContent))
+	if err := checkEventSize(tpl, nil); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	results := make([]*raceResult, len(prefixes))
+	for i, pm := range rm.prefixes {
+		results[i] = &raceResult{Prefix: rm.names[i], Expected: 1 / pm.Probability()}
+	}
+	var workers []*Worker
+	var lock sync.Mutex
+	var ranking []*raceResult
+	start := time.Now()
+	// In porcelain mode, the ranking table is human-readable text, so it goes to stderr along with other progress output.
+	tableOut := os.Stdout
+	if *porcelain {
+		tableOut = os.Stderr
+	}
+	printRanking := func() {
+		_, _ = fmt.Fprintf(tableOut, "%-4s  %-12s  %12s  %12s  %8s  %-14s  %s\n", "Rank", "Prefix", "Hashes", "Expected", "Ratio", "Time", "Room ID")
+		for i, res := range ranking {
+			_, _ = fmt.Fprintf(tableOut, "%-4d  %-12s  %12d  %12.4g  %8.3f  %-14s  %s\n", i+1, res.Prefix, res.Hashes, res.Expected, float64(res.Hashes)/res.Expected, res.Elapsed.Round(time.Millisecond), res.Candidate.RoomID())
+		}
+		totalNow := totalHashes(workers)
+		for _, res := range results {
+			if res.Candidate == nil {
+				_, _ = fmt.Fprintf(tableOut, "%-4s  %-12s  %12s  %12.4g  %8s  %-14s  %s\n", "-", res.Prefix, fmt.Sprintf(">%d", totalNow), res.Expected, "-", "-", "not found")
+			}
+		}
+		for _, res := range ranking {
+			if *porcelain {
+				_ = json.NewEncoder(os.Stdout).Encode(NewPorcelainResult(res.Candidate))
+			} else {
+				fmt.Println(string(res.Candidate.PDU))
+			}
+		}
+	}
+	onFound := func(c *Candidate) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		for i, pm := range rm.prefixes {
+			if !pm.Match([]byte(c.EventID)) || !rm.markFound(i) {
+				continue
+			}
+			res := results[i]
+			res.Candidate = c
+			res.Hashes = totalHashes(workers)
+			res.Elapsed = time.Since(start)
+			ranking = append(ranking, res)
+			_, _ = fmt.Fprintln(os.Stderr, "Found", res.Prefix, "after", res.Hashes, "hashes (expected", int64(res.Expected), "hashes):", c.RoomID())
+		}
+		if len(ranking) == len(results) {
+			printRanking()
+			os.Exit(ExitFound)
+		}
+		return true
+	}
+	workers = newWorkers([]*Template{tpl}, CompileMatcher(rm), onFound)
+	var wg sync.WaitGroup
+	startWorkers(workers, &wg)
+	if *maxSeconds < 0 {
+		wg.Wait()
+	} else {
+		time.Sleep(time.Until(start.Add(time.Duration(*maxSeconds) * time.Second)))
+	}
+	lock.Lock()
+	printRanking()
+	if len(ranking) == 0 {
+		fatalf(ExitNotFound, "None of the prefixes were found")
+	}
+	os.Exit(ExitFound)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// candidateHeap is a min-heap of near-miss candidates ordered by how well they matched,
+// so the worst candidate can be replaced when a better one is found.
+type candidateHeap []*Candidate
+
+func (h candidateHeap) Len() int           { return len(h) }
+func (h candidateHeap) Less(i, j int) bool { return h[i].MatchedLength < h[j].MatchedLength }
+func (h candidateHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x any)        { *h = append(*h, x.(*Candidate)) }
+func (h *candidateHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// accepts returns true if a candidate with the given matched length would make it into a heap of size n.
+func (h candidateHeap) accepts(matchedLength, n int) bool {
+	return n > 0 && (len(h) < n || matchedLength > h[0].MatchedLength)
+}
+
+// offer adds the candidate to the heap, dropping the worst candidate if the heap is already full.
+func (h *candidateHeap) offer(c *Candidate, n int) {
+	if len(*h) < n {
+		heap.Push(h, c)
+	} else {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// rankCandidates returns the n best near-miss candidates across all the given workers, best first.
+func rankCandidates(workers []*Worker, n int) []*Candidate {
+	var all []*Candidate
+	for _, w := range workers {
+		all = append(all, w.Top()...)
+	}
+	slices.SortStableFunc(all, func(a, b *Candidate) int {
+		return cmp.Or(cmp.Compare(b.MatchedLength, a.MatchedLength), cmp.Compare(a.Duration, b.Duration))
+	})
+	return all[:min(n, len(all))]
+}
+
+// printTopCandidates prints a ranked list of the best candidates to stderr if --top-candidates is set.
+func printTopCandidates(workers []*Worker) {
+	if *topCandidates <= 0 {
+		return
+	}
+	ranked := rankCandidates(workers, *topCandidates)
+	if len(ranked) == 0 {
+		return
+	}
+	target := firstTargetPrefix()
+	scorer := activeScorer()
+	if scorer != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Top %d most %s candidates:\n", len(ranked), scorer)
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "Top %d candidates for %s:\n", len(ranked), target)
+	}
+	for i, c := range ranked {
+		creationContent, _ := json.Marshal(createRoomRequest(c)["creation_content"])
+		matched := fmt.Sprintf("matched %d/%d", c.MatchedLength, len(target))
+		if scorer != "" {
+			matched = fmt.Sprintf("%s %d", scorer, c.MatchedLength)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "%3d. %s  %s  creation_content %s\n", i+1, c.RoomID(), matched, creationContent)
+	}
+}
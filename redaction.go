@@ -0,0 +1,113 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/exerrors"
+)
+
+// redactionRules describe what the redaction algorithm of a room version keeps.
+type redactionRules struct {
+	// Top-level keys that are kept.
+	Keys []string
+	// Content keys that are kept for each event type. Content of other event types is removed entirely.
+	ContentKeys map[string][]string
+	// Event types whose content is kept entirely.
+	FullContentTypes []string
+	// Whether the signed part of third_party_invite is kept in member events.
+	ThirdPartyInviteSigned bool
+}
+
+// redactionRulesFor returns the redaction rules of the given numbered room version.
+// Later room versions that are based on room version 11 use its rules.
+func redactionRulesFor(version int) *redactionRules {
+	rules := &redactionRules{
+		Keys: []string{
+			"event_id", "type", "room_id", "sender", "state_key", "content", "hashes",
+			"signatures", "depth", "prev_events", "auth_events", "origin_server_ts",
+		},
+		ContentKeys: map[string][]string{
+			"m.room.member":             {"membership"},
+			"m.room.create":             {"creator"},
+			"m.room.join_rules":         {"join_rule"},
+			"m.room.history_visibility": {"history_visibility"},
+			"m.room.power_levels": {
+				"ban", "events", "events_default", "kick",
+				"redact", "state_default", "users", "users_default",
+			},
+		},
+	}
+	if version < 6 {
+		rules.ContentKeys["m.room.aliases"] = []string{"aliases"}
+	}
+	if version >= 8 {
+		rules.ContentKeys["m.room.join_rules"] = append(rules.ContentKeys["m.room.join_rules"], "allow")
+	}
+	if version >= 9 {
+		rules.ContentKeys["m.room.member"] = append(rules.ContentKeys["m.room.member"], "join_authorised_via_users_server")
+	}
+	if version >= 11 {
+		delete(rules.ContentKeys, "m.room.create")
+		rules.FullContentTypes = []string{"m.room.create"}
+		rules.ContentKeys["m.room.power_levels"] = append(rules.ContentKeys["m.room.power_levels"], "invite")
+		rules.ContentKeys["m.room.redaction"] = []string{"redacts"}
+		rules.ThirdPartyInviteSigned = true
+	} else {
+		rules.Keys = append(rules.Keys, "origin", "membership", "prev_state")
+	}
+	return rules
+}
+
+// redactionRulesOf returns the redaction rules that apply to the given event. Create events specify their room
+// version themselves, while other events are assumed to be in the room version given with --room-version.
+func redactionRulesOf(pdu []byte) *redactionRules {
+	parsed := gjson.ParseBytes(pdu)
+	version := *roomVersion
+	if parsed.Get("type").Str == "m.room.create" {
+		version = parsed.Get("content.room_version").Str
+	} else if version == "" {
+		version = defaultPDURoomVersion
+	}
+	if info, ok := getRoomVersion(version); ok && info.Redaction != nil {
+		return info.Redaction
+	}
+	return unstableRoomVersion.Redaction
+}
+
+// redactPDU applies the given redaction rules to the event. The result is not in canonical form.
+func redactPDU(pdu []byte, rules *redactionRules) []byte {
+	parsed := gjson.ParseBytes(pdu)
+	eventType := parsed.Get("type").Str
+	redacted := make(map[string]json.RawMessage)
+	parsed.ForEach(func(key, value gjson.Result) bool {
+		if slices.Contains(rules.Keys, key.Str) {
+			redacted[key.Str] = json.RawMessage(value.Raw)
+		}
+		return true
+	})
+	if _, ok := redacted["content"]; ok && !slices.Contains(rules.FullContentTypes, eventType) {
+		content := make(map[string]json.RawMessage)
+		keptKeys := rules.ContentKeys[eventType]
+		parsed.Get("content").ForEach(func(key, value gjson.Result) bool {
+			if eventType == "m.room.member" && key.Str == "third_party_invite" && rules.ThirdPartyInviteSigned {
+				// Only the signed part of third party invites is kept.
+				if signed := value.Get("signed"); signed.Exists() {
+					content[key.Str] = exerrors.Must(json.Marshal(map[string]json.RawMessage{"signed": json.RawMessage(signed.Raw)}))
+				}
+			} else if slices.Contains(keptKeys, key.Str) {
+				content[key.Str] = json.RawMessage(value.Raw)
+			}
+			return true
+		})
+		redacted["content"] = exerrors.Must(json.Marshal(content))
+	}
+	return exerrors.Must(json.Marshal(redacted))
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+)
+
+func TestRedactPDU(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  int
+		pdu      string
+		expected string
+	}{{
+		"CreateV10", 10,
+		`{"type":"m.room.create","sender":"@a:b","origin":"b","content":{"creator":"@a:b","room_version":"10","m.federate":false},"unsigned":{"age":1},"hashes":{"sha256":"x"}}`,
+		`{"content":{"creator":"@a:b"},"hashes":{"sha256":"x"},"origin":"b","sender":"@a:b","type":"m.room.create"}`,
+	}, {
+		"CreateV11", 11,
+		`{"type":"m.room.create","sender":"@a:b","origin":"b","content":{"room_version":"11","m.federate":false,"fi.mau.randomness":"abc"},"hashes":{"sha256":"x"}}`,
+		`{"content":{"fi.mau.randomness":"abc","m.federate":false,"room_version":"11"},"hashes":{"sha256":"x"},"sender":"@a:b","type":"m.room.create"}`,
+	}, {
+		"PowerLevelsV10", 10,
+		`{"type":"m.room.power_levels","state_key":"","content":{"ban":50,"invite":0,"notifications":{"room":50},"users":{"@a:b":100}}}`,
+		`{"content":{"ban":50,"users":{"@a:b":100}},"state_key":"","type":"m.room.power_levels"}`,
+	}, {
+		"PowerLevelsV11", 11,
+		`{"type":"m.room.power_levels","state_key":"","content":{"ban":50,"invite":0,"notifications":{"room":50},"users":{"@a:b":100}}}`,
+		`{"content":{"ban":50,"invite":0,"users":{"@a:b":100}},"state_key":"","type":"m.room.power_levels"}`,
+	}, {
+		"JoinRulesV7", 7,
+		`{"type":"m.room.join_rules","state_key":"","content":{"join_rule":"restricted","allow":[]}}`,
+		`{"content":{"join_rule":"restricted"},"state_key":"","type":"m.room.join_rules"}`,
+	}, {
+		"JoinRulesV8", 8,
+		`{"type":"m.room.join_rules","state_key":"","content":{"join_rule":"restricted","allow":[]}}`,
+		`{"content":{"allow":[],"join_rule":"restricted"},"state_key":"","type":"m.room.join_rules"}`,
+	}, {
+		"MemberThirdPartyInviteV10", 10,
+		`{"type":"m.room.member","state_key":"@a:b","content":{"membership":"invite","displayname":"a","third_party_invite":{"display_name":"a","signed":{"token":"t"}}}}`,
+		`{"content":{"membership":"invite"},"state_key":"@a:b","type":"m.room.member"}`,
+	}, {
+		"MemberThirdPartyInviteV11", 11,
+		`{"type":"m.room.member","state_key":"@a:b","content":{"membership":"invite","displayname":"a","third_party_invite":{"display_name":"a","signed":{"token":"t"}}}}`,
+		`{"content":{"membership":"invite","third_party_invite":{"signed":{"token":"t"}}},"state_key":"@a:b","type":"m.room.member"}`,
+	}, {
+		"AliasesV5", 5,
+		`{"type":"m.room.aliases","state_key":"b","content":{"aliases":["#a:b"],"other":1}}`,
+		`{"content":{"aliases":["#a:b"]},"state_key":"b","type":"m.room.aliases"}`,
+	}, {
+		"AliasesV6", 6,
+		`{"type":"m.room.aliases","state_key":"b","content":{"aliases":["#a:b"],"other":1}}`,
+		`{"content":{},"state_key":"b","type":"m.room.aliases"}`,
+	}, {
+		"MessageV11", 11,
+		`{"type":"m.room.message","origin":"b","membership":"join","prev_state":[],"content":{"body":"meow"}}`,
+		`{"content":{},"type":"m.room.message"}`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted := canonicaljson.CanonicalJSONAssumeValid(redactPDU([]byte(test.pdu), redactionRulesFor(test.version)))
+			if string(redacted) != test.expected {
+				t.Errorf("unexpected redaction result\nexpected %s\ngot      %s", test.expected, redacted)
+			}
+		})
+	}
+}
+
+func TestRedactionRulesOf(t *testing.T) {
+	pdu := []byte(`{"type":"m.room.create","content":{"room_version":"10"}}`)
+	if rules := redactionRulesOf(pdu); !slices.Contains(rules.ContentKeys["m.room.create"], "creator") {
+		t.Errorf("expected room version 10 rules for a version 10 create event")
+	}
+	pdu = []byte(`{"type":"m.room.create","content":{"room_version":"12"}}`)
+	if rules := redactionRulesOf(pdu); !slices.Contains(rules.FullContentTypes, "m.room.create") {
+		t.Errorf("expected create content to be kept in room version 12")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// The maximum number of distinct NFA state sets to track when computing the exact match probability of a regex.
+const regexMaxStateSets = 100000
+
+// Number of random event IDs to test when the exact probability can't be computed.
+const regexProbabilitySamples = 1000000
+
+// RegexMatcher accepts event IDs matching a regular expression.
+type RegexMatcher struct {
+	re      *regexp.Regexp
+	literal []byte
+	prob    float64
+}
+
+var _ LiteralPrefixer = (*RegexMatcher)(nil)
+
+func NewRegexMatcher(pattern string) (*RegexMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	rm := &RegexMatcher{re: re}
+	// The literal prefix of the regex is only a prefix of the event ID if the regex is anchored to the start.
+	if prog.StartCond()&syntax.EmptyBeginText != 0 {
+		literal, _ := re.LiteralPrefix()
+		rm.literal = []byte(literal)
+	}
+	var ok bool
+	rm.prob, ok = regexProbability(prog)
+	if !ok {
+		rm.prob = sampleProbability(rm, nil)
+	}
+	return rm, nil
+}
+
+func (rm *RegexMatcher) Match(eventID []byte) bool {
+	return rm.re.Match(eventID)
+}
+
+func (rm *RegexMatcher) Probability() float64 {
+	return rm.prob
+}
+
+func (rm *RegexMatcher) LiteralPrefix() []byte {
+	return rm.literal
+}
+
+// eventIDCharsAt returns the characters that can appear at the given position of an event ID.
+func eventIDCharsAt(pos int) string {
+	dataChars := (sha256.Size*8 + 5) / 6
+	switch {
+	case pos >= dataChars:
+		return "="
+	case pos == dataChars-1:
+		// The last character only encodes the remaining 4 bits, so the 2 lowest bits of its index are always zero.
+		var chars strings.Builder
+		for i := 0; i < len(eventIDAlphabet); i += 4 {
+			chars.WriteByte(eventIDAlphabet[i])
+		}
+		return chars.String()
+	default:
+		return eventIDAlphabet
+	}
+}
+
+type regexStateSet struct {
+	pcs  []uint32
+	prev rune
+	p    float64
+}
+
+// regexProbability computes the exact probability that a random event ID matches the program by simulating
+// the NFA over all possible event IDs at once, tracking the probability of reaching each set of NFA states.
+// Returns false if the number of state sets grows too large.
+func regexProbability(prog *syntax.Prog) (float64, bool) {
+	// Word boundaries depend on the previous character, so it only needs to be tracked if the program has any.
+	trackPrev := false
+	for _, inst := range prog.Inst {
+		if inst.Op == syntax.InstEmptyWidth && syntax.EmptyOp(inst.Arg)&(syntax.EmptyWordBoundary|syntax.EmptyNoWordBoundary) != 0 {
+			trackPrev = true
+		}
+	}
+	closure := func(pcs []uint32, r1, r2 rune) (out []uint32, matched bool) {
+		ctx := syntax.EmptyOpContext(r1, r2)
+		visited := make(map[uint32]bool)
+		var visit func(pc uint32)
+		visit = func(pc uint32) {
+			if visited[pc] || matched {
+				return
+			}
+			visited[pc] = true
+			inst := &prog.Inst[pc]
+			switch inst.Op {
+			case syntax.InstAlt, syntax.InstAltMatch:
+				visit(inst.Out)
+				visit(inst.Arg)
+			case syntax.InstCapture, syntax.InstNop:
+				visit(inst.Out)
+			case syntax.InstEmptyWidth:
+				if syntax.EmptyOp(inst.Arg)&^ctx == 0 {
+					visit(inst.Out)
+				}
+			case syntax.InstMatch:
+				matched = true
+			case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+				out = append(out, pc)
+			}
+		}
+		for _, pc := range pcs {
+			visit(pc)
+		}
+		return
+	}
+	length := eventIDLength()
+	current := map[string]*regexStateSet{"": {prev: -1, p: 1}}
+	var matchedP float64
+	for pos := 0; pos <= length; pos++ {
+		next := make(map[string]*regexStateSet)
+		var chars string
+		if pos < length {
+			chars = eventIDCharsAt(pos)
+		}
+		for _, st := range current {
+			// The regex isn't anchored by default, so a match may start at any position.
+			pcs := append(slices.Clone(st.pcs), uint32(prog.Start))
+			if pos == length {
+				if _, matched := closure(pcs, st.prev, -1); matched {
+					matchedP += st.p
+				}
+				continue
+			}
+			charP := st.p / float64(len(chars))
+			for _, char := range chars {
+				active, matched := closure(pcs, st.prev, char)
+				if matched {
+					matchedP += charP
+					continue
+				}
+				var nextPCs []uint32
+				for _, pc := range active {
+					if inst := &prog.Inst[pc]; inst.MatchRune(char) {
+						nextPCs = append(nextPCs, inst.Out)
+					}
+				}
+				slices.Sort(nextPCs)
+				nextPCs = slices.Compact(nextPCs)
+				// When the previous character doesn't matter, NUL is used to mark that it's not the start of the text.
+				prev := rune(0)
+				if trackPrev {
+					prev = char
+				}
+				key := fmt.Sprint(prev, nextPCs)
+				if existing, ok := next[key]; ok {
+					existing.p += charP
+				} else {
+					next[key] = &regexStateSet{pcs: nextPCs, prev: prev, p: charP}
+				}
+			}
+		}
+		if len(next) > regexMaxStateSets {
+			return 0, false
+		}
+		current = next
+	}
+	return matchedP, true
+}
+
+// sampleProbability estimates the match probability by testing random event IDs.
+// If the matcher only accepts IDs with a known literal prefix, the samples are given that prefix,
+// so that the estimate stays accurate even when matches are far too rare to sample directly.
+func sampleProbability(m Matcher, prefix []byte) float64 {
+	hash := make([]byte, sha256.Size)
+	eventID := make([]byte, eventIDLength())
+	var hits int
+	for i := 0; i < regexProbabilitySamples; i++ {
+		_, _ = rand.Read(hash)
+		eventIDEncoding.Encode(eventID, hash)
+		copy(eventID, prefix)
+		if m.Match(eventID) {
+			hits++
+		}
+	}
+	// Assume half a hit if there were none, as the probability is definitely not zero.
+	return charProbability(len(prefix)) * max(float64(hits), 0.5) / regexProbabilitySamples
+}
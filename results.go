@@ -0,0 +1,78 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// writeFileAtomic writes the file through a temporary file in the same directory,
+// so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	err := os.WriteFile(tmpPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	return err
+}
+
+var resultFileLock sync.Mutex
+
+// The results written to the -o file so far, used when searching for more than one result with --count.
+var outputFileResults []*PorcelainResult
+
+// writeOutputFile writes the result to the file given with -o in the --porcelain format.
+// With --count, the file contains a list of all the results found so far instead.
+func writeOutputFile(c *Candidate) {
+	if *outputPath == "" {
+		return
+	}
+	resultFileLock.Lock()
+	defer resultFileLock.Unlock()
+	var data []byte
+	if *resultCount > 1 {
+		outputFileResults = append(outputFileResults, NewPorcelainResult(c))
+		data, _ = json.Marshal(outputFileResults)
+	} else {
+		data, _ = json.Marshal(NewPorcelainResult(c))
+	}
+	if err := writeFileAtomic(*outputPath, append(data, '\n')); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to write result to", *outputPath, "-", err)
+	}
+}
+
+// JournalEntry is a line in the --journal file.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	*PorcelainResult
+}
+
+// appendJournal appends the result to the file given with --journal, which is never truncated.
+func appendJournal(c *Candidate) {
+	if *journalPath == "" {
+		return
+	}
+	resultFileLock.Lock()
+	defer resultFileLock.Unlock()
+	data, _ := json.Marshal(&JournalEntry{Time: time.Now(), PorcelainResult: NewPorcelainResult(c)})
+	file, err := os.OpenFile(*journalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = file.Write(append(data, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to append result to", *journalPath, "-", err)
+	}
+}
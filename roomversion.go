@@ -0,0 +1,130 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type roomVersionInfo struct {
+	// Whether the room ID is the reference hash of the create event rather than picked by the server.
+	DerivedRoomID bool
+	// Whether the create event can list additional_creators.
+	AdditionalCreators bool
+	// Whether event IDs are reference hashes encoded with unpadded URL-safe base64, which is required for mining events.
+	HashEventIDs bool
+	// What's kept when redacting events. The reference hash that determines the event ID is calculated over the redacted event.
+	Redaction *redactionRules
+}
+
+// Known room versions. All versions where the room ID is derived use reference hashes encoded with
+// unpadded URL-safe base64 and don't have the creator key in the create content, which is what templates assume.
+var roomVersions = map[string]roomVersionInfo{
+	"1":                   {Redaction: redactionRulesFor(1)},
+	"2":                   {Redaction: redactionRulesFor(2)},
+	"3":                   {Redaction: redactionRulesFor(3)},
+	"4":                   {HashEventIDs: true, Redaction: redactionRulesFor(4)},
+	"5":                   {HashEventIDs: true, Redaction: redactionRulesFor(5)},
+	"6":                   {HashEventIDs: true, Redaction: redactionRulesFor(6)},
+	"7":                   {HashEventIDs: true, Redaction: redactionRulesFor(7)},
+	"8":                   {HashEventIDs: true, Redaction: redactionRulesFor(8)},
+	"9":                   {HashEventIDs: true, Redaction: redactionRulesFor(9)},
+	"10":                  {HashEventIDs: true, Redaction: redactionRulesFor(10)},
+	"11":                  {HashEventIDs: true, Redaction: redactionRulesFor(11)},
+	"12":                  {DerivedRoomID: true, AdditionalCreators: true, HashEventIDs: true, Redaction: redactionRulesFor(12)},
+	"org.matrix.hydra.11": {DerivedRoomID: true, AdditionalCreators: true, HashEventIDs: true, Redaction: redactionRulesFor(11)},
+}
+
+// The room version assumed for the pdu command if --room-version isn't given.
+const defaultPDURoomVersion = "12"
+
+// Unknown room versions are assumed to work like the latest known one with --unstable-room-versions.
+var unstableRoomVersion = roomVersions["12"]
+
+// getRoomVersion returns the rules of the given room version, or false if it's unknown and unstable versions aren't allowed.
+func getRoomVersion(version string) (roomVersionInfo, bool) {
+	if info, ok := roomVersions[version]; ok {
+		return info, true
+	} else if *unstableRoomVersions {
+		return unstableRoomVersion, true
+	}
+	return roomVersionInfo{}, false
+}
+
+// applyRoomVersion sets the room_version in the given create content to the one given with --room-version,
+// replacing any existing one, as the default content has a room version too.
+func applyRoomVersion(createContent string) (string, error) {
+	if *roomVersion == "" {
+		return createContent, nil
+	} else if !gjson.Valid(createContent) {
+		return "", fmt.Errorf("invalid create event content")
+	}
+	updated, err := sjson.Set(createContent, "room_version", *roomVersion)
+	if err != nil {
+		return "", fmt.Errorf("failed to set room version in create content: %w", err)
+	}
+	return updated, nil
+}
+
+// checkRoomVersion checks that the room ID is derived from the create event in the room version of the given content,
+// and that the content follows the rules of the room version, so that homeservers won't reject the event after mining.
+func checkRoomVersion(createContent string) error {
+	if !gjson.Valid(createContent) || !gjson.Parse(createContent).IsObject() {
+		return fmt.Errorf("the create content must be a JSON object")
+	}
+	version := gjson.Get(createContent, "room_version")
+	if version.Type != gjson.String {
+		return fmt.Errorf("the create content must have room_version as a string")
+	}
+	info, ok := getRoomVersion(version.Str)
+	if !ok {
+		return fmt.Errorf("unknown room version %q (use --unstable-room-versions to try it as if it was room version 12)", version.Str)
+	} else if _, known := roomVersions[version.Str]; !known {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown room version %q, assuming room IDs are derived like in room version 12\n", version.Str)
+	}
+	if !info.DerivedRoomID {
+		return fmt.Errorf("room IDs in room version %s are picked by the server rather than derived from the create event, so they can't be bruteforced (room version 12 or newer is required)", version.Str)
+	}
+	return checkCreateContent(gjson.Parse(createContent), version.Str, info)
+}
+
+func checkCreateContent(content gjson.Result, version string, info roomVersionInfo) error {
+	if content.Get("creator").Exists() {
+		return fmt.Errorf("creator isn't used in room version %s, the sender of the create event is the creator, so remove it from the create content", version)
+	} else if federate := content.Get("m\\.federate"); federate.Exists() && !federate.IsBool() {
+		return fmt.Errorf("m.federate in the create content must be a boolean")
+	} else if roomType := content.Get("type"); roomType.Exists() && roomType.Type != gjson.String {
+		return fmt.Errorf("type in the create content must be a string")
+	}
+	if predecessor := content.Get("predecessor"); predecessor.Exists() {
+		if !predecessor.IsObject() {
+			return fmt.Errorf("predecessor in the create content must be an object")
+		} else if roomID := predecessor.Get("room_id"); roomID.Type != gjson.String || !strings.HasPrefix(roomID.Str, "!") {
+			return fmt.Errorf("predecessor in the create content must have the room_id of the previous room")
+		}
+	}
+	if creators := content.Get("additional_creators"); creators.Exists() {
+		if !info.AdditionalCreators {
+			return fmt.Errorf("room version %s doesn't support additional_creators", version)
+		} else if !creators.IsArray() {
+			return fmt.Errorf("additional_creators in the create content must be a list of user IDs")
+		}
+		for _, userID := range creators.Array() {
+			if _, _, err := id.UserID(userID.Str).Parse(); userID.Type != gjson.String || err != nil {
+				return fmt.Errorf("additional_creators in the create content contains an invalid user ID %s", userID.Raw)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// ScriptFilter runs candidates through a match(event_id, event_json) function defined in a Lua script.
+// Unlike matchers, the script sees the full PDU, so it's applied to candidates that the matcher has already
+// accepted. Lua states aren't thread-safe, so each concurrent caller gets its own state from a pool.
+type ScriptFilter struct {
+	name  string
+	proto *lua.FunctionProto
+	pool  sync.Pool
+}
+
+// loadScriptFilter loads the script given with --match-script, or returns nil if there isn't one.
+func loadScriptFilter() (*ScriptFilter, error) {
+	if *matchScript == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(*matchScript)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(data)), *matchScript)
+	if err != nil {
+		return nil, err
+	}
+	sf := &ScriptFilter{name: *matchScript}
+	if sf.proto, err = lua.Compile(chunk, *matchScript); err != nil {
+		return nil, err
+	}
+	// Create the first state right away to find errors in the script before starting the search.
+	L, err := sf.newState()
+	if err != nil {
+		return nil, err
+	}
+	sf.pool.Put(L)
+	return sf, nil
+}
+
+func (sf *ScriptFilter) newState() (*lua.LState, error) {
+	L := lua.NewState()
+	L.Push(L.NewFunctionFromProto(sf.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	} else if L.GetGlobal("match").Type() != lua.LTFunction {
+		L.Close()
+		return nil, fmt.Errorf("%s doesn't define a match function", sf.name)
+	}
+	return L, nil
+}
+
+// Accept calls the match function of the script with the candidate's event ID and PDU.
+func (sf *ScriptFilter) Accept(c *Candidate) (bool, error) {
+	L, ok := sf.pool.Get().(*lua.LState)
+	if !ok {
+		var err error
+		if L, err = sf.newState(); err != nil {
+			return false, err
+		}
+	}
+	defer sf.pool.Put(L)
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal("match"), NRet: 1, Protect: true}, lua.LString(c.EventID), lua.LString(c.PDU))
+	if err != nil {
+		return false, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return lua.LVAsBool(ret), nil
+}
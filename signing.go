@@ -0,0 +1,137 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+// loadSigningKeys reads a Synapse-format signing key file, which contains one key per line.
+// Lines may also be raw base64-encoded ed25519 seeds, which are given the ID from --key-id.
+func loadSigningKeys(path string) ([]*federation.SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*federation.SigningKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var key *federation.SigningKey
+		if fields := strings.Fields(line); len(fields) == 1 {
+			key, err = parseRawSeed(fields[0], id.KeyID(*signingKeyID))
+		} else if len(fields) == 3 && decodeSeed(fields[2]) == nil {
+			err = fmt.Errorf("invalid private key: must be a base64-encoded %d-byte ed25519 seed", ed25519.SeedSize)
+		} else {
+			key, err = federation.ParseSynapseKey(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key on line %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return keys, nil
+}
+
+func decodeSeed(seed string) []byte {
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.NewReplacer("-", "+", "_", "/").Replace(seed), "="))
+	if err != nil || len(decoded) != ed25519.SeedSize {
+		return nil
+	}
+	return decoded
+}
+
+// parseRawSeed parses a raw ed25519 seed in standard or URL-safe base64. Raw seeds don't include a key ID,
+// so it must be given with --key-id.
+func parseRawSeed(seed string, keyID id.KeyID) (*federation.SigningKey, error) {
+	decoded := decodeSeed(seed)
+	if decoded == nil {
+		return nil, fmt.Errorf("invalid raw seed: must be a base64-encoded %d-byte ed25519 seed", ed25519.SeedSize)
+	} else if keyID == "" {
+		return nil, fmt.Errorf("raw seeds don't include a key ID, so --key-id is required")
+	} else if !strings.Contains(string(keyID), ":") {
+		keyID = id.NewKeyID(id.KeyAlgorithmEd25519, string(keyID))
+	}
+	priv := ed25519.NewKeyFromSeed(decoded)
+	return &federation.SigningKey{
+		ID:   keyID,
+		Pub:  id.SigningKey(base64.RawStdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))),
+		Priv: priv,
+	}, nil
+}
+
+// selectSigningKey picks the key with the given ID from a key file, or the first key if the ID is empty.
+//
+// Synapse always signs with the first key in the file and treats the rest as previous keys,
+// so picking any other key will most likely produce signatures that other servers consider expired.
+func selectSigningKey(keys []*federation.SigningKey, keyID id.KeyID) (*federation.SigningKey, error) {
+	if keyID == "" {
+		return keys[0], nil
+	}
+	if !strings.Contains(string(keyID), ":") {
+		keyID = id.NewKeyID(id.KeyAlgorithmEd25519, string(keyID))
+	}
+	for i, key := range keys {
+		if key.ID == keyID {
+			if i != 0 {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: %s is not the first key in the file, so it's probably an expired key (the active key is %s)\n", keyID, keys[0].ID)
+			}
+			return key, nil
+		}
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = string(key.ID)
+	}
+	return nil, fmt.Errorf("key %s not found in file (available keys: %s)", keyID, strings.Join(ids, ", "))
+}
+
+// loadSelectedSigningKey loads the key specified by --signing-key and --key-id, or returns nil if no key file was specified.
+func loadSelectedSigningKey() (*federation.SigningKey, error) {
+	if *signingKeyPath == "" {
+		if *signingKeyID != "" {
+			return nil, fmt.Errorf("--key-id requires --signing-key")
+		}
+		return nil, nil
+	}
+	keys, err := loadSigningKeys(*signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return selectSigningKey(keys, id.KeyID(*signingKeyID))
+}
+
+// signPDU adds a signature from the given server and key to a canonical JSON event.
+//
+// Signatures are calculated over the redacted event without the unsigned data. Create events are not affected by
+// redaction, but most other events are. The signature is not part of the reference hash, so it doesn't change the event ID.
+func signPDU(pdu []byte, serverName string, key *federation.SigningKey) []byte {
+	unsigned := exerrors.Must(sjson.DeleteBytes(pdu, "signatures"))
+	// Redaction also removes the unsigned data.
+	redacted := canonicaljson.CanonicalJSONAssumeValid(redactPDU(unsigned, redactionRulesOf(unsigned)))
+	signature := base64.RawStdEncoding.EncodeToString(key.SignRawJSON(redacted))
+	signed := exerrors.Must(sjson.SetBytes(unsigned, "signatures", map[string]map[id.KeyID]string{
+		serverName: {key.ID: signature},
+	}))
+	return canonicaljson.CanonicalJSONAssumeValid(signed)
+}
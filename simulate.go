@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+var simulatePercentiles = []float64{10, 25, 50, 75, 90, 95, 99, 99.9}
+
+func runSimulate() {
+	if *hashrate <= 0 {
+		fatalf(ExitInvalidInput, "--hashrate must be set to a positive number of hashes per second")
+	} else if *simulateTrials <= 0 {
+		fatalf(ExitInvalidInput, "--trials must be positive")
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	p := matcher.Probability()
+	if p == 0 {
+		fatalf(ExitUsage, "--score searches until the time limit, so there's nothing to simulate")
+	}
+	attempts := simulateAttempts(p, *simulateTrials)
+	fmt.Printf("Simulated %d searches at %.0f hashes/s (match chance %.3g per hash, expected %.4g hashes)\n", len(attempts), *hashrate, p, 1/p)
+	for _, pct := range simulatePercentiles {
+		n := attempts[int(math.Ceil(pct/100*float64(len(attempts))))-1]
+		fmt.Printf("%5.1f%% found within %12.4g hashes / %s\n", pct, n, formatSeconds(n / *hashrate))
+	}
+	if *watts > 0 {
+		er := &EnergyReport{JoulesPerHash: *watts / *hashrate}
+		er.ExpectedJoules = er.JoulesPerHash / p
+		er.setPrice(*pricePerKWh)
+		fmt.Printf("Expected energy per solution at %g W: %s", *watts, formatEnergy(er.ExpectedJoules))
+		if er.PricePerKWh > 0 {
+			fmt.Printf(", costing %.4g", er.ExpectedCost)
+		}
+		fmt.Println()
+	}
+	if *maxSeconds >= 0 {
+		limit := float64(*maxSeconds) * *hashrate
+		found, _ := slices.BinarySearch(attempts, limit)
+		fmt.Printf("%.2f%% of searches finished within the %ds time limit\n", float64(found)/float64(len(attempts))*100, *maxSeconds)
+	}
+}
+
+// simulateAttempts samples the number of hashes needed to find a match with per-hash probability p
+// and returns the sorted samples. The number of attempts is geometrically distributed,
+// so it can be sampled directly using the inverse CDF instead of simulating individual hashes.
+func simulateAttempts(p float64, trials int) []float64 {
+	attempts := make([]float64, trials)
+	logMiss := math.Log1p(-p)
+	for i := range attempts {
+		attempts[i] = max(1, math.Ceil(math.Log(1-rand.Float64())/logMiss))
+	}
+	slices.Sort(attempts)
+	return attempts
+}
+
+// formatSeconds formats a duration that may be too long to fit in a time.Duration.
+func formatSeconds(sec float64) string {
+	switch {
+	case sec < 72*60*60:
+		return time.Duration(sec * float64(time.Second)).Round(time.Millisecond).String()
+	case sec < 365*24*60*60:
+		return fmt.Sprintf("%.1f days", sec/(24*60*60))
+	default:
+		return fmt.Sprintf("%.4g years", sec/(365*24*60*60))
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tidwall/gjson"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+// The maximum size of a PDU in canonical JSON, homeservers reject larger events.
+const maxPDUSize = 65536
+
+// Events larger than this get a warning, as homeservers may add more signatures or unsigned data to them.
+const pduSizeWarningThreshold = maxPDUSize * 9 / 10
+
+// checkEventSize checks that the events made from the given template fit in the PDU size limit, including
+// the signature if a signing key is given. Every event made from a template has the same size, as the
+// randomness and content hash have a fixed length.
+func checkEventSize(tpl *Template, signingKey *federation.SigningKey) error {
+	size := eventSize(tpl, signingKey)
+	if size > maxPDUSize {
+		return fmt.Errorf("the event would be %d bytes, which is over the limit of %d bytes", size, maxPDUSize)
+	} else if size > pduSizeWarningThreshold {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: the event will be %d bytes, which is close to the limit of %d bytes\n", size, maxPDUSize)
+	}
+	return nil
+}
+
+func eventSize(tpl *Template, signingKey *federation.SigningKey) int {
+	pdu := tpl.FullPDU([]byte(placeholderSHA256))
+	if signingKey != nil {
+		pdu = signPDU(pdu, id.UserID(gjson.GetBytes(pdu, "sender").Str).Homeserver(), signingKey)
+	}
+	return len(pdu)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var spaceChildren = flag.Make().LongKey("children").Usage("Number of child rooms to bruteforce in addition to the space").Default("3").Int()
+
+// SpaceBundle is written to stdout by the space command. The /createRoom request bodies include the
+// m.space.child and m.space.parent events that link the rooms together as initial_state.
+type SpaceBundle struct {
+	Space    *PorcelainResult   `json:"space"`
+	Children []*PorcelainResult `json:"children"`
+}
+
+// initialStateEvent is an entry in the initial_state list of a /createRoom request.
+type initialStateEvent struct {
+	Type     event.Type `json:"type"`
+	StateKey string     `json:"state_key"`
+	Content  any        `json:"content"`
+}
+
+// newSpaceBundle creates the output for the given space and child room create events.
+func newSpaceBundle(space *Candidate, children []*Candidate) *SpaceBundle {
+	via := []string{space.Sender().Homeserver()}
+	bundle := &SpaceBundle{Space: NewPorcelainResult(space)}
+	var spaceState []*initialStateEvent
+	for _, child := range children {
+		spaceState = append(spaceState, &initialStateEvent{
+			Type:     event.StateSpaceChild,
+			StateKey: child.RoomID().String(),
+			Content:  &event.SpaceChildEventContent{Via: via},
+		})
+		result := NewPorcelainResult(child)
+		result.CreateRoom["initial_state"] = []*initialStateEvent{{
+			Type:     event.StateSpaceParent,
+			StateKey: space.RoomID().String(),
+			Content:  &event.SpaceParentEventContent{Via: via, Canonical: true},
+		}}
+		bundle.Children = append(bundle.Children, result)
+	}
+	bundle.Space.CreateRoom["initial_state"] = spaceState
+	return bundle
+}
+
+// runSpace bruteforces a space and --children child rooms whose IDs all match, and outputs a SpaceBundle.
+func runSpace() {
+	creatorUserID := id.UserID(*creator)
+	if _, _, err := creatorUserID.Parse(); err != nil {
+		fatalf(ExitInvalidInput, "Invalid user ID: %s", *creator)
+	} else if len(senders()) > 1 {
+		fatalf(ExitInvalidInput, "Space mode only supports a single user ID")
+	} else if !json.Valid([]byte(*createContent)) {
+		fatalf(ExitInvalidInput, "Invalid create event content")
+	} else if *spaceChildren < 1 {
+		fatalf(ExitInvalidInput, "--children must be at least 1")
+	} else if err := checkRoomVersion(*createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err := checkTemplateFlags(*timestamp, *createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	signingKey, err := loadSelectedSigningKey()
+	if err != nil {
+		fatal(ExitInvalidInput, "Failed to load signing key:", err)
+	}
+	var deadline <-chan time.Time
+	if *maxSeconds >= 0 {
+		deadline = time.After(time.Duration(*maxSeconds) * time.Second)
+	}
+	spaceContent := exerrors.Must(sjson.Set(*createContent, "type", event.RoomTypeSpace))
+	spaceTpl := NewCreateTemplate(creatorUserID, *timestamp, json.RawMessage(spaceContent))
+	childTpl := NewCreateTemplate(creatorUserID, *timestamp, json.RawMessage(*createContent))
+	for _, tpl := range []*Template{spaceTpl, childTpl} {
+		if err = checkEventSize(tpl, signingKey); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Mining space")
+	space := mineTemplate(spaceTpl, matcher, 1, deadline)
+	if len(space) == 0 {
+		exitWithError(ExitNotFound, "No matching space found in the time limit")
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Found space", space[0].RoomID(), "- mining", *spaceChildren, "child rooms")
+	children := mineTemplate(childTpl, matcher, *spaceChildren, deadline)
+	if len(children) < *spaceChildren {
+		exitWithError(ExitNotFound, fmt.Sprintf("Only found %d/%d child rooms in the time limit", len(children), *spaceChildren))
+	}
+	if signingKey != nil {
+		for _, c := range append(space, children...) {
+			c.PDU = signPDU(c.PDU, creatorUserID.Homeserver(), signingKey)
+		}
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(newSpaceBundle(space[0], children))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const statusUpdateInterval = 1 * time.Second
+
+const StateRunning = "running"
+
+// Status is the content of the file written with --status-file. The file is replaced atomically
+// on every update, so readers never see a partially written file.
+type Status struct {
+	Version   int       `json:"version"`
+	PID       int       `json:"pid"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID    id.UserID `json:"user_id"`
+	Prefix    string    `json:"prefix"`
+	Timestamp int64     `json:"timestamp"`
+	Threads   int       `json:"threads"`
+
+	Hashes   uint64  `json:"hashes"`
+	Hashrate float64 `json:"hashrate"`
+	// The expected total number of hashes needed to find a match.
+	ExpectedHashes float64 `json:"expected_hashes"`
+	// The probability that a match would have been found with the number of hashes checked so far.
+	Progress float64 `json:"progress"`
+	// The expected time until a match is found at the current hashrate. Misses don't bring a match
+	// any closer, so this doesn't decrease over time.
+	ETASeconds float64 `json:"eta_seconds"`
+
+	Best   *StatusCandidate `json:"best,omitempty"`
+	Result *StatusCandidate `json:"result,omitempty"`
+}
+
+type StatusCandidate struct {
+	RoomID        id.RoomID `json:"room_id"`
+	MatchedLength int       `json:"matched_length,omitempty"`
+}
+
+type statusWriter struct {
+	path       string
+	start      time.Time
+	workers    func() []*Worker
+	matcher    Matcher
+	lock       sync.Mutex
+	lastHashes uint64
+	lastUpdate time.Time
+	stop       chan struct{}
+}
+
+// startStatusWriter starts periodically writing the status file. The workers function must return a copy of the current workers.
+func startStatusWriter(path string, start time.Time, matcher Matcher, workers func() []*Worker) *statusWriter {
+	sw := &statusWriter{path: path, start: start, workers: workers, matcher: matcher, lastUpdate: start, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(statusUpdateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sw.write(StateRunning, sw.workers(), nil)
+			case <-sw.stop:
+				return
+			}
+		}
+	}()
+	return sw
+}
+
+func (sw *statusWriter) write(state string, workers []*Worker, result *Candidate) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	now := time.Now()
+	p := sw.matcher.Probability()
+	status := &Status{
+		Version:   1,
+		PID:       os.Getpid(),
+		State:     state,
+		StartedAt: sw.start,
+		UpdatedAt: now,
+		UserID:    id.UserID(*creator),
+		Prefix:    *prefix,
+		Timestamp: *timestamp,
+		Threads:   int(*threadCount),
+		Hashes:    totalHashes(workers),
+	}
+	// With --score, nothing is ever a match, so the expected hashes, progress and ETA are left at zero.
+	if p > 0 {
+		status.ExpectedHashes = 1 / p
+	}
+	if state != StateRunning {
+		// The final update may come right after a periodic one, so use the average over the whole run instead.
+		status.Hashrate = float64(status.Hashes) / now.Sub(sw.start).Seconds()
+	} else if elapsed := now.Sub(sw.lastUpdate).Seconds(); elapsed > 0 && status.Hashes >= sw.lastHashes {
+		status.Hashrate = float64(status.Hashes-sw.lastHashes) / elapsed
+	}
+	sw.lastHashes, sw.lastUpdate = status.Hashes, now
+	status.Progress = -math.Expm1(float64(status.Hashes) * math.Log1p(-p))
+	if status.Hashrate > 0 && p > 0 {
+		status.ETASeconds = status.ExpectedHashes / status.Hashrate
+	}
+	if best := bestCandidate(workers); best != nil {
+		status.Best = &StatusCandidate{RoomID: best.RoomID(), MatchedLength: best.MatchedLength}
+	}
+	if result != nil {
+		status.Result = &StatusCandidate{RoomID: result.RoomID(), MatchedLength: result.MatchedLength}
+	}
+	data, _ := json.Marshal(status)
+	if err := writeFileAtomic(sw.path, data); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to write status file:", err)
+	}
+}
+
+// Finish stops the periodic updates and writes the final state. It's safe to call on a nil writer.
+func (sw *statusWriter) Finish(outcome string, workers []*Worker, result *Candidate) {
+	if sw == nil {
+		return
+	}
+	close(sw.stop)
+	sw.write(outcome, workers, result)
+}
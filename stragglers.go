@@ -0,0 +1,157 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const stragglerCheckInterval = 10 * time.Second
+
+// How many consecutive checks a thread must be flagged as slow before its range is rebalanced.
+const stragglerRebalanceStrikes = 3
+
+// stragglerMonitor periodically compares the hashrates of workers and flags ones that are significantly slower than the median.
+type stragglerMonitor struct {
+	workers []*Worker
+	wg      *sync.WaitGroup
+	// Called with the new worker when a straggler is replaced.
+	replaced func(*Worker)
+
+	lastHashes map[*Worker]uint64
+	strikes    map[*Worker]int
+}
+
+func monitorStragglers(workers []*Worker, wg *sync.WaitGroup, replaced func(*Worker), stop <-chan struct{}) {
+	if *stragglerThreshold <= 0 || len(workers) < 2 {
+		return
+	}
+	sm := &stragglerMonitor{
+		workers:    slices.Clone(workers),
+		wg:         wg,
+		replaced:   replaced,
+		lastHashes: make(map[*Worker]uint64),
+		strikes:    make(map[*Worker]int),
+	}
+	ticker := time.NewTicker(stragglerCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sm.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (sm *stragglerMonitor) check() {
+	// Workers that have exited don't check any hashes, so they'd always look like stragglers.
+	sm.workers = slices.DeleteFunc(sm.workers, (*Worker).Exited)
+	if len(sm.workers) < 2 {
+		return
+	}
+	rates := make([]float64, len(sm.workers))
+	for i, w := range sm.workers {
+		hashes := w.Hashes()
+		rates[i] = float64(hashes-sm.lastHashes[w]) / stragglerCheckInterval.Seconds()
+		sm.lastHashes[w] = hashes
+	}
+	sorted := slices.Clone(rates)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	var mean, variance float64
+	for _, rate := range rates {
+		mean += rate
+	}
+	mean /= float64(len(rates))
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(rates)))
+	var slow []*Worker
+	healthy := 0
+	for i, w := range sm.workers {
+		if rates[i] >= median**stragglerThreshold {
+			sm.strikes[w] = 0
+			healthy++
+			continue
+		}
+		sm.strikes[w]++
+		_, _ = fmt.Fprintf(os.Stderr, "Thread ID %d is running at %.0f hashes/s, which is %.0f%% of the median %.0f hashes/s (mean %.0f, stddev %.0f)\n",
+			w.ThreadID, rates[i], rates[i]/median*100, median, mean, stddev)
+		if *rebalanceStragglers && sm.strikes[w] >= stragglerRebalanceStrikes {
+			slow = append(slow, w)
+		}
+	}
+	for _, w := range slow {
+		sm.workers = slices.DeleteFunc(sm.workers, func(other *Worker) bool { return other == w })
+		delete(sm.strikes, w)
+		delete(sm.lastHashes, w)
+		sm.workers = append(sm.workers, sm.rebalance(w, max(healthy, 1))...)
+	}
+}
+
+// rebalance stops a straggler and splits the rest of its counter range at the current timestamp evenly between
+// fresh workers, one for each healthy worker. The last one also takes over the timestamps after the current one.
+func (sm *stragglerMonitor) rebalance(w *Worker, parts int) []*Worker {
+	// Hold the wait group while the old worker is replaced, so that the round doesn't end in between.
+	sm.wg.Add(1)
+	defer sm.wg.Done()
+	w.Stop()
+	<-w.Done()
+	start, end := w.NextCounter(), w.EndCounter
+	if end == 0 {
+		end = w.Template.counterEnd()
+	}
+	// Zero means the counter can use all 64 bits, which wraps around to the right size here.
+	size := end - start
+	if size == 0 {
+		size = math.MaxUint64
+	}
+	replacements := make([]*Worker, 0, parts)
+	for i := 0; i < parts; i++ {
+		partStart := start + splitRange(size, i, parts)
+		partEnd := start + splitRange(size, i+1, parts)
+		if i == parts-1 {
+			partEnd = w.EndCounter
+		} else if partStart == partEnd {
+			continue
+		}
+		replacement := newWorker(w.ThreadID, w.Template, w.Matcher, w.OnFound)
+		replacement.BestEffortTarget = w.BestEffortTarget
+		replacement.Scorer = w.Scorer
+		replacement.TopCandidates = w.TopCandidates
+		replacement.NearMissLength = w.NearMissLength
+		replacement.OnNearMiss = w.OnNearMiss
+		replacement.Process = w.Process
+		replacement.Affinity = w.Affinity
+		replacement.StartCounter = partStart
+		replacement.EndCounter = partEnd
+		replacements = append(replacements, replacement)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Split the remaining range of thread ID", w.ThreadID, "from counter", start, "between", len(replacements), "new workers")
+	sm.wg.Add(len(replacements))
+	for _, replacement := range replacements {
+		sm.replaced(replacement)
+		go replacement.Run(sm.wg.Done)
+	}
+	return replacements
+}
+
+// splitRange returns where the ith of n equal parts of a range of the given size starts, without overflowing.
+func splitRange(size uint64, i, n int) uint64 {
+	hi, lo := bits.Mul64(size, uint64(i))
+	quotient, _ := bits.Div64(hi, lo, uint64(n))
+	return quotient
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestSplitRange(t *testing.T) {
+	for _, size := range []uint64{1, 2, 7, 1 << 16, math.MaxUint64} {
+		for n := 1; n <= 5; n++ {
+			if got := splitRange(size, 0, n); got != 0 {
+				t.Errorf("splitRange(%d, 0, %d) = %d", size, n, got)
+			}
+			if got := splitRange(size, n, n); got != size {
+				t.Errorf("splitRange(%d, %d, %d) = %d", size, n, n, got)
+			}
+			for i := 0; i < n; i++ {
+				if splitRange(size, i, n) > splitRange(size, i+1, n) {
+					t.Errorf("parts of %d aren't in order", size)
+				}
+			}
+		}
+	}
+}
+
+// TestRebalance stops a worker part way through its range, splits the rest between three replacements
+// and checks that every counter value is checked exactly once.
+func TestRebalance(t *testing.T) {
+	oldLength := *randomnessLength
+	*randomnessLength = 4
+	t.Cleanup(func() { *randomnessLength = oldLength })
+	tpl := NewCreateTemplate("@meow:example.com", 1735689600000, json.RawMessage(`{"room_version":"12"}`))
+	const startCounter, stopAfter = 1000, 5000
+
+	var lock sync.Mutex
+	seen := make(map[uint16]int)
+	limit := stopAfter
+	onFound := func(c *Candidate) bool {
+		randomness, err := base64.RawURLEncoding.DecodeString(gjson.GetBytes(c.PDU, "content.fi\\.mau\\.randomness").Str)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		seen[binary.NativeEndian.Uint16(randomness[2:])]++
+		return len(seen) < limit
+	}
+	w := newWorker(1, tpl, CompileMatcher(PrefixMatcher(nil)), onFound)
+	w.StartCounter = startCounter
+	w.Run(func() {})
+	if next := w.NextCounter(); next != startCounter+stopAfter {
+		t.Fatalf("expected the next counter to be %d, got %d", startCounter+stopAfter, next)
+	}
+
+	var wg sync.WaitGroup
+	sm := &stragglerMonitor{wg: &wg, replaced: func(*Worker) {}}
+	// Let the replacements run to the end of the range.
+	lock.Lock()
+	limit = math.MaxInt
+	lock.Unlock()
+	replacements := sm.rebalance(w, 3)
+	if len(replacements) != 3 {
+		t.Fatalf("expected 3 replacements, got %d", len(replacements))
+	}
+	wg.Wait()
+	end := (&Template{RandomnessLength: 4}).counterEnd()
+	if uint64(len(seen)) != end-startCounter {
+		t.Errorf("expected %d counter values to be checked, got %d", end-startCounter, len(seen))
+	}
+	for counter, n := range seen {
+		if uint64(counter) < startCounter {
+			t.Errorf("counter %d before the start was checked", counter)
+		} else if n != 1 {
+			t.Errorf("counter %d was checked %d times", counter, n)
+		}
+	}
+}
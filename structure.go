@@ -0,0 +1,99 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// StructureMatcher accepts event IDs whose first characters have a structure rather than specific values,
+// like being all the same character or a palindrome.
+type StructureMatcher struct {
+	kind       string
+	length     int
+	ignoreCase bool
+}
+
+// structureKinds lists the supported structures and what they mean, for error messages.
+var structureKinds = map[string]string{
+	"repeated":   "the first N characters are identical",
+	"palindrome": "the first N characters read the same backwards",
+}
+
+// NewStructureMatcher parses a structure in the form kind:length, e.g. repeated:6 or palindrome:8.
+func NewStructureMatcher(spec string, ignoreCase bool) (*StructureMatcher, error) {
+	kind, lengthStr, ok := strings.Cut(spec, ":")
+	if _, known := structureKinds[kind]; !known || !ok {
+		var kinds []string
+		for _, name := range slices.Sorted(maps.Keys(structureKinds)) {
+			kinds = append(kinds, fmt.Sprintf("%s:N (%s)", name, structureKinds[name]))
+		}
+		return nil, fmt.Errorf("unknown pattern %q, supported patterns are %s", spec, strings.Join(kinds, ", "))
+	}
+	length, err := strconv.Atoi(lengthStr)
+	// The last character of the event ID has a restricted alphabet, so stay clear of it.
+	if err != nil || length < 2 || length >= eventIDLength() {
+		return nil, fmt.Errorf("invalid pattern length %q, must be between 2 and %d", lengthStr, eventIDLength()-1)
+	}
+	return &StructureMatcher{kind: kind, length: length, ignoreCase: ignoreCase}, nil
+}
+
+func (sm *StructureMatcher) equal(a, b byte) bool {
+	if sm.ignoreCase && isASCIILetter(a) && isASCIILetter(b) {
+		return a&caseFoldMask == b&caseFoldMask
+	}
+	return a == b
+}
+
+func (sm *StructureMatcher) Match(eventID []byte) bool {
+	if len(eventID) < sm.length {
+		return false
+	}
+	switch sm.kind {
+	case "repeated":
+		for i := 1; i < sm.length; i++ {
+			if !sm.equal(eventID[i], eventID[0]) {
+				return false
+			}
+		}
+	case "palindrome":
+		for i := 0; i < sm.length/2; i++ {
+			if !sm.equal(eventID[i], eventID[sm.length-1-i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// charEqualProbability returns the chance of n random characters all being equal to a given random character.
+func (sm *StructureMatcher) charEqualProbability(n int) (p float64) {
+	for i := 0; i < len(eventIDAlphabet); i++ {
+		same := 1.0
+		if sm.ignoreCase && isASCIILetter(eventIDAlphabet[i]) && strings.IndexByte(eventIDAlphabet, eventIDAlphabet[i]^0x20) != -1 {
+			same = 2
+		}
+		p += math.Pow(same/float64(len(eventIDAlphabet)), float64(n))
+	}
+	return p / float64(len(eventIDAlphabet))
+}
+
+func (sm *StructureMatcher) Probability() float64 {
+	switch sm.kind {
+	case "repeated":
+		return sm.charEqualProbability(sm.length - 1)
+	case "palindrome":
+		return math.Pow(sm.charEqualProbability(1), float64(sm.length/2))
+	default:
+		return 0
+	}
+}
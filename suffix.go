@@ -0,0 +1,61 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SuffixMatcher accepts event IDs that end with the given suffix, optionally in any combination of cases.
+type SuffixMatcher struct {
+	value []byte
+	mask  []byte
+}
+
+func NewSuffixMatcher(suffix string, ignoreCase bool) (*SuffixMatcher, error) {
+	if err := checkAlphabet(suffix); err != nil {
+		return nil, err
+	} else if len(suffix) > maxPrefixLength {
+		return nil, fmt.Errorf("suffix %s too long, must be at most %d characters", suffix, maxPrefixLength)
+	}
+	sm := &SuffixMatcher{value: []byte(suffix), mask: bytes.Repeat([]byte{0xff}, len(suffix))}
+	if ignoreCase {
+		sm.value, sm.mask = foldPattern(suffix)
+	}
+	// The last character of an event ID only encodes 4 bits, so only a quarter of the alphabet can appear there.
+	lastPos := eventIDLength() - 1
+	if sm.charProbability(len(suffix)-1, lastPos) == 0 {
+		return nil, fmt.Errorf("room IDs can't end with %c, the last character must be one of %s", suffix[len(suffix)-1], eventIDCharsAt(lastPos))
+	}
+	return sm, nil
+}
+
+// charProbability returns the chance of the i:th character of the suffix matching at the given position of the event ID.
+func (sm *SuffixMatcher) charProbability(i, pos int) float64 {
+	chars := eventIDCharsAt(pos)
+	var matching int
+	for j := 0; j < len(chars); j++ {
+		if chars[j]&sm.mask[i] == sm.value[i] {
+			matching++
+		}
+	}
+	return float64(matching) / float64(len(chars))
+}
+
+func (sm *SuffixMatcher) Match(eventID []byte) bool {
+	return len(eventID) >= len(sm.value) && matchMasked(eventID[len(eventID)-len(sm.value):], sm.value, sm.mask)
+}
+
+func (sm *SuffixMatcher) Probability() float64 {
+	p := 1.0
+	start := eventIDLength() - len(sm.value)
+	for i := range sm.value {
+		p *= sm.charProbability(i, start+i)
+	}
+	return p
+}
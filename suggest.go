@@ -0,0 +1,67 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// alphabetReplacements are the closest event ID characters for common characters that can't appear in event IDs.
+// + and / are the standard base64 equivalents of - and _.
+var alphabetReplacements = map[rune]string{
+	'+': "-",
+	'/': "_",
+	' ': "_",
+	'.': "_",
+	'ß': "ss",
+	'æ': "ae",
+	'Æ': "AE",
+	'ø': "o",
+	'Ø': "O",
+	'ł': "l",
+	'Ł': "L",
+}
+
+// checkAlphabet returns an error if the literal contains characters that aren't in the event ID alphabet,
+// suggesting the nearest achievable variant if there is one.
+func checkAlphabet(literal string) error {
+	var invalid []string
+	var suggestion strings.Builder
+	for _, char := range literal {
+		if char < 0x80 && strings.IndexByte(eventIDAlphabet, byte(char)) != -1 {
+			suggestion.WriteRune(char)
+			continue
+		}
+		invalid = append(invalid, fmt.Sprintf("%q", char))
+		replacement, ok := alphabetReplacements[char]
+		if !ok {
+			// Drop accents by decomposing the character and only keeping the base letter.
+			for _, part := range norm.NFD.String(string(char)) {
+				if !unicode.Is(unicode.Mn, part) {
+					replacement += string(part)
+				}
+			}
+		}
+		for _, part := range replacement {
+			if part < 0x80 && strings.IndexByte(eventIDAlphabet, byte(part)) != -1 {
+				suggestion.WriteRune(part)
+			}
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%s contains characters that can't appear in room IDs (%s)", literal, strings.Join(invalid, ", "))
+	if suggestion.Len() > 0 {
+		err = fmt.Errorf("%w, try %s instead", err, suggestion.String())
+	}
+	return err
+}
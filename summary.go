@@ -0,0 +1,235 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	OutcomeFound       = "found"
+	OutcomeTimeout     = "timeout"
+	OutcomeInterrupted = "interrupted"
+	OutcomeExhausted   = "exhausted"
+)
+
+// RunSummary is a self-contained description of a bruteforce run, written to the file specified with --summary.
+type RunSummary struct {
+	Outcome         string          `json:"outcome"`
+	StartedAt       time.Time       `json:"started_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	TotalHashes     uint64          `json:"total_hashes"`
+	Hashrate        float64         `json:"hashrate"`
+	Config          SummaryConfig   `json:"config"`
+	Hardware        SummaryHardware `json:"hardware"`
+	Threads         []SummaryThread `json:"threads"`
+	Result          *SummaryResult  `json:"result,omitempty"`
+	Energy          *EnergyReport   `json:"energy,omitempty"`
+	// A command that continues the search without repeating already checked hashes.
+	ResumeCommand string `json:"resume_command,omitempty"`
+}
+
+type SummaryConfig struct {
+	UserID     id.UserID       `json:"user_id"`
+	Prefix     string          `json:"prefix"`
+	Content    json.RawMessage `json:"content"`
+	Timestamp  int64           `json:"timestamp"`
+	Threads    uint16          `json:"threads"`
+	IndexStart uint16          `json:"index_start"`
+	MaxSeconds int             `json:"max_seconds"`
+}
+
+type SummaryHardware struct {
+	Hostname  string `json:"hostname,omitempty"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	CPUs      int    `json:"cpus"`
+	CPUModel  string `json:"cpu_model,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+type SummaryThread struct {
+	ThreadID uint16 `json:"thread_id"`
+	// Only set with --random-start, as threads otherwise start from zero.
+	StartCounter uint64  `json:"start_counter,omitempty"`
+	Hashes       uint64  `json:"hashes"`
+	Hashrate     float64 `json:"hashrate"`
+}
+
+type SummaryResult struct {
+	RoomID   id.RoomID       `json:"room_id"`
+	ThreadID uint16          `json:"thread_id"`
+	PDU      json.RawMessage `json:"pdu"`
+}
+
+func newRunSummary(outcome string, start time.Time, workers []*Worker, result *Candidate, energy *EnergyReport) *RunSummary {
+	dur := time.Since(start)
+	rs := &RunSummary{
+		Outcome:         outcome,
+		StartedAt:       start,
+		DurationSeconds: dur.Seconds(),
+		Config: SummaryConfig{
+			UserID:     id.UserID(*creator),
+			Prefix:     *prefix,
+			Content:    json.RawMessage(*createContent),
+			Timestamp:  *timestamp,
+			Threads:    *threadCount,
+			IndexStart: *threadIndexStart,
+			MaxSeconds: *maxSeconds,
+		},
+		Hardware: getHardwareInfo(),
+		Threads:  make([]SummaryThread, len(workers)),
+		Energy:   energy,
+	}
+	for i, w := range workers {
+		hashes := w.Hashes()
+		rs.TotalHashes += hashes
+		rs.Threads[i] = SummaryThread{ThreadID: w.ThreadID, StartCounter: w.StartCounter, Hashes: hashes, Hashrate: float64(hashes) / dur.Seconds()}
+	}
+	rs.Hashrate = float64(rs.TotalHashes) / dur.Seconds()
+	if result != nil {
+		rs.Result = &SummaryResult{RoomID: result.RoomID(), ThreadID: result.ThreadID, PDU: result.PDU}
+	}
+	if outcome != OutcomeFound {
+		rs.ResumeCommand = resumeCommand()
+	}
+	return rs
+}
+
+// resumeCommand returns a command that continues the current search using the next unused range of thread indexes.
+// The original arguments are repeated as-is, so every flag that was set is kept, and the overrides are appended
+// at the end, as later values replace earlier ones. The timestamp is always pinned, as it defaults to the current time.
+func resumeCommand() string {
+	args := append([]string{"matrix-rig"}, os.Args[1:]...)
+	if *noRandomness {
+		// Thread indexes aren't part of events without randomness, so continue in the next timestamp window instead.
+		window := *timestampWindow * 1000
+		args = append(args, "-t", strconv.FormatInt(*timestamp+2*window+1, 10))
+	} else {
+		args = append(args,
+			"-t", strconv.FormatInt(*timestamp, 10),
+			"-i", strconv.Itoa(int(*threadIndexStart)+int(*threadCount)),
+		)
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:@/=,") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func getHardwareInfo() SummaryHardware {
+	hostname, _ := os.Hostname()
+	return SummaryHardware{
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		CPUModel:  getCPUModel(),
+		GoVersion: runtime.Version(),
+	}
+}
+
+func getCPUModel() string {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Write writes the summary to the given path as Markdown if the file extension is .md, or as JSON otherwise.
+func (rs *RunSummary) Write(path string) error {
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".md") {
+		data = rs.Markdown()
+	} else if data, err = json.MarshalIndent(rs, "", "  "); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (rs *RunSummary) Markdown() []byte {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "# matrix-rig run summary\n\n")
+	_, _ = fmt.Fprintf(&buf, "* Outcome: **%s**\n", rs.Outcome)
+	_, _ = fmt.Fprintf(&buf, "* Started at: %s\n", rs.StartedAt.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(&buf, "* Duration: %s\n", formatSeconds(rs.DurationSeconds))
+	_, _ = fmt.Fprintf(&buf, "* Total hashes: %d (%.0f hashes/s)\n", rs.TotalHashes, rs.Hashrate)
+	if rs.Result != nil {
+		_, _ = fmt.Fprintf(&buf, "\n## Result\n\n* Room ID: `%s`\n* Found by thread: %d\n\n```json\n%s\n```\n", rs.Result.RoomID, rs.Result.ThreadID, rs.Result.PDU)
+	}
+	if rs.Energy != nil {
+		_, _ = fmt.Fprintf(&buf, "\n## Energy\n\n* Used: %s (%s)\n", formatEnergy(rs.Energy.Joules), rs.Energy.Source)
+		_, _ = fmt.Fprintf(&buf, "* Per hash: %.3g J\n", rs.Energy.JoulesPerHash)
+		_, _ = fmt.Fprintf(&buf, "* Expected per solution: %s\n", formatEnergy(rs.Energy.ExpectedJoules))
+		if rs.Energy.PricePerKWh > 0 {
+			_, _ = fmt.Fprintf(&buf, "* Cost: %.4g (expected %.4g per solution at %g per kWh)\n", rs.Energy.Cost, rs.Energy.ExpectedCost, rs.Energy.PricePerKWh)
+		}
+	}
+	_, _ = fmt.Fprintf(&buf, "\n## Configuration\n\n")
+	_, _ = fmt.Fprintf(&buf, "* User ID: `%s`\n", rs.Config.UserID)
+	_, _ = fmt.Fprintf(&buf, "* Prefix: `%s`\n", rs.Config.Prefix)
+	_, _ = fmt.Fprintf(&buf, "* Content: `%s`\n", rs.Config.Content)
+	_, _ = fmt.Fprintf(&buf, "* Timestamp: %d\n", rs.Config.Timestamp)
+	_, _ = fmt.Fprintf(&buf, "* Threads: %d (starting from index %d)\n", rs.Config.Threads, rs.Config.IndexStart)
+	_, _ = fmt.Fprintf(&buf, "* Time limit: %d seconds\n", rs.Config.MaxSeconds)
+	_, _ = fmt.Fprintf(&buf, "\n## Hardware\n\n")
+	if rs.Hardware.Hostname != "" {
+		_, _ = fmt.Fprintf(&buf, "* Hostname: %s\n", rs.Hardware.Hostname)
+	}
+	_, _ = fmt.Fprintf(&buf, "* Platform: %s/%s, %d CPUs\n", rs.Hardware.OS, rs.Hardware.Arch, rs.Hardware.CPUs)
+	if rs.Hardware.CPUModel != "" {
+		_, _ = fmt.Fprintf(&buf, "* CPU: %s\n", rs.Hardware.CPUModel)
+	}
+	_, _ = fmt.Fprintf(&buf, "* Go version: %s\n", rs.Hardware.GoVersion)
+	_, _ = fmt.Fprintf(&buf, "\n## Threads\n\n| Thread ID | Hashes | Hashes/s |\n|---|---|---|\n")
+	for _, t := range rs.Threads {
+		_, _ = fmt.Fprintf(&buf, "| %d | %d | %.0f |\n", t.ThreadID, t.Hashes, t.Hashrate)
+	}
+	if rs.ResumeCommand != "" {
+		_, _ = fmt.Fprintf(&buf, "\n## Resuming\n\nThe following command continues the search with the next unused thread indexes:\n\n```sh\n%s\n```\n", rs.ResumeCommand)
+	}
+	return buf.Bytes()
+}
+
+// writeSummary writes the run summary if --summary was specified.
+func writeSummary(outcome string, start time.Time, workers []*Worker, result *Candidate, energy *EnergyReport) {
+	if *summaryPath == "" {
+		return
+	}
+	err := newRunSummary(outcome, start, workers, result, energy).Write(*summaryPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to write summary:", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+	"go.mau.fi/util/exgjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+type CreatePDU struct {
+	AuthEvents     []string        `json:"auth_events"`
+	PrevEvents     []string        `json:"prev_events"`
+	Depth          int             `json:"depth"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	Sender         id.UserID       `json:"sender"`
+	StateKey       string          `json:"state_key"`
+	Type           string          `json:"type"`
+	Content        json.RawMessage `json:"content"`
+}
+
+type Hashes struct {
+	SHA256 string `json:"sha256"`
+}
+
+const randomnessField = "fi.mau.randomness"
+
+// Keys of the create event content that have a meaning in the spec, so they can't be used for randomness.
+var reservedCreateContentKeys = []string{"creator", "m.federate", "room_version", "predecessor", "type", "additional_creators"}
+
+// randomnessPath returns the path of the randomness in the create event content, as set with --randomness-field.
+// Nested fields are separated with slashes, as dots are common in namespaced keys.
+func randomnessPath() []string {
+	return strings.Split(*randomnessFieldPath, "/")
+}
+
+const placeholderRandomness = "PLCEHOLD"
+
+// The supported range and default of --randomness-length. The first 2 bytes are always the thread ID.
+const (
+	minRandomnessLength     = 4
+	maxRandomnessLength     = 16
+	defaultRandomnessLength = 10
+)
+
+// randomnessPlaceholder returns a placeholder for randomness of the given length in bytes,
+// which is placeholderRandomness repeated to the length of the encoded randomness.
+func randomnessPlaceholder(length int) string {
+	encodedLength := base64.RawURLEncoding.EncodedLen(length)
+	return strings.Repeat(placeholderRandomness, encodedLength/len(placeholderRandomness)+1)[:encodedLength]
+}
+
+const placeholderSHA256 = "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"
+
+var base64SHA256Length = base64.RawURLEncoding.EncodedLen(sha256.Size)
+
+// Template contains the canonical JSON forms of a create event with placeholder slots for the randomness and content hash.
+type Template struct {
+	// The event without the hashes field, which is used for calculating the content hash.
+	PDU []byte
+	// The redacted event including the content hash, which is used for calculating the reference hash (i.e. the event ID).
+	PDUWithHash []byte
+	// Whether redaction removes anything from the event, in which case PDUWithHash isn't the full event.
+	Redacted bool
+
+	// The length of the randomness in bytes, before encoding it. Zero if the event doesn't have randomness.
+	RandomnessLength int
+
+	// Byte offsets of the randomness slot in both events and the content hash slot in PDUWithHash.
+	// These are stored rather than searched for, because workers overwrite the placeholders.
+	// RandomOffsetWithHash is -1 if the randomness is removed by redaction.
+	RandomOffset         int
+	RandomOffsetWithHash int
+	HashOffset           int
+	// Byte offsets of the origin_server_ts digits in both events, used for varying the timestamp.
+	TimestampOffset         int
+	TimestampOffsetWithHash int
+
+	// The timestamp currently in the events, and the range it may be varied in with --timestamp-window.
+	OriginalTimestamp int64
+	Timestamp         int64
+	MinTimestamp      int64
+	MaxTimestamp      int64
+	// The position of the current timestamp in the window, counting up from the original timestamp and wrapping
+	// around to MinTimestamp, and how many positions to move at a time. Workers without randomness share
+	// the window by each starting at a different position and stepping over the others.
+	TimestampPosition int64
+	TimestampStep     int64
+}
+
+func NewCreateTemplate(sender id.UserID, ts int64, content json.RawMessage) *Template {
+	createPDU := &CreatePDU{
+		AuthEvents:     []string{},
+		PrevEvents:     []string{},
+		Depth:          1,
+		OriginServerTS: ts,
+		Sender:         sender,
+		StateKey:       "",
+		Type:           "m.room.create",
+		Content:        content,
+	}
+	return newTemplate(exerrors.Must(json.Marshal(createPDU)), ts)
+}
+
+// newTemplate creates a template for the given event, which must not have hashes, signatures or unsigned data.
+func newTemplate(pdu []byte, ts int64) *Template {
+	length := *randomnessLength
+	if *noRandomness {
+		length = 0
+	}
+	placeholder, hashPlaceholder := randomnessPlaceholder(length), placeholderSHA256
+	for {
+		tpl := newTemplateWithPlaceholders(pdu, ts, length, placeholder, hashPlaceholder)
+		if tpl != nil {
+			return tpl
+		}
+		// The event happens to contain one of the placeholders, so the offsets would be ambiguous.
+		// Random placeholders of the same length are practically guaranteed to be unique.
+		placeholder = base64.RawURLEncoding.EncodeToString(randomBytes(length))
+		hashPlaceholder = base64.RawURLEncoding.EncodeToString(randomBytes(sha256.Size))
+	}
+}
+
+// randomBytes returns the given number of bytes from crypto/rand.
+func randomBytes(n int) []byte {
+	buf := make([]byte, n)
+	exerrors.Must(rand.Read(buf))
+	return buf
+}
+
+// newTemplateWithPlaceholders builds a template using the given placeholders,
+// or returns nil if a placeholder doesn't occur exactly once in the events.
+func newTemplateWithPlaceholders(pdu []byte, ts int64, length int, placeholder, hashPlaceholder string) *Template {
+	if length > 0 {
+		pdu = exerrors.Must(sjson.SetBytes(pdu, exgjson.Path(append([]string{"content"}, randomnessPath()...)...), placeholder))
+	}
+	pduJSON := canonicaljson.CanonicalJSONAssumeValid(pdu)
+	pduJSONWithHashField := exerrors.Must(sjson.SetBytes(bytes.Clone(pdu), "hashes", &Hashes{SHA256: hashPlaceholder}))
+	pduJSONWithHashField = canonicaljson.CanonicalJSONAssumeValid(pduJSONWithHashField)
+	// The reference hash is calculated over the redacted event. Create events aren't affected by redaction in room
+	// versions where the room ID is derived, but most of the content of other events is removed, which may include
+	// the randomness.
+	redactedWithHashField := canonicaljson.CanonicalJSONAssumeValid(redactPDU(pduJSONWithHashField, redactionRulesOf(pdu)))
+	randomnessInRedacted := bytes.Count(redactedWithHashField, []byte(placeholder))
+	if bytes.Count(redactedWithHashField, []byte(hashPlaceholder)) != 1 ||
+		(length > 0 && (bytes.Count(pduJSON, []byte(placeholder)) != 1 || randomnessInRedacted > 1)) {
+		return nil
+	}
+	tpl := &Template{
+		PDU:              pduJSON,
+		PDUWithHash:      redactedWithHashField,
+		Redacted:         !bytes.Equal(pduJSONWithHashField, redactedWithHashField),
+		RandomnessLength: length,
+		HashOffset:       bytes.Index(redactedWithHashField, []byte(hashPlaceholder)),
+		// The content comes before origin_server_ts in canonical JSON and may contain the same key,
+		// but everything after it is either a string or a list of strings, so the last occurrence is the right one.
+		TimestampOffset:         bytes.LastIndex(pduJSON, []byte(timestampKey)) + len(timestampKey),
+		TimestampOffsetWithHash: bytes.LastIndex(redactedWithHashField, []byte(timestampKey)) + len(timestampKey),
+		OriginalTimestamp:       ts,
+		Timestamp:               ts,
+		MinTimestamp:            ts,
+		MaxTimestamp:            ts,
+		TimestampStep:           1,
+	}
+	if length > 0 {
+		tpl.RandomOffset = bytes.Index(pduJSON, []byte(placeholder))
+		tpl.RandomOffsetWithHash = -1
+		if randomnessInRedacted == 1 {
+			tpl.RandomOffsetWithHash = bytes.Index(redactedWithHashField, []byte(placeholder))
+		}
+	}
+	if window := *timestampWindow * 1000; window > 0 {
+		tpl.MinTimestamp, tpl.MaxTimestamp = ts-window, ts+window
+	}
+	return tpl
+}
+
+// FullPDU returns the unredacted event with the given content hash, which is what's output for candidates
+// of templates that are affected by redaction. For other templates, PDUWithHash is already the full event.
+func (tpl *Template) FullPDU(contentHash []byte) []byte {
+	pdu := exerrors.Must(sjson.SetBytes(bytes.Clone(tpl.PDU), "hashes", &Hashes{SHA256: string(contentHash)}))
+	return canonicaljson.CanonicalJSONAssumeValid(pdu)
+}
+
+const timestampKey = `"origin_server_ts":`
+
+// checkTemplateFlags validates the flags that affect how templates are built for an event with the given content.
+// All the timestamps in the --timestamp-window around ts must have the same number of digits,
+// as the timestamp is overwritten in place.
+func checkTemplateFlags(ts int64, content string) error {
+	window := *timestampWindow * 1000
+	path := randomnessPath()
+	if slices.Contains(path, "") {
+		return fmt.Errorf("--randomness-field can't contain empty keys")
+	} else if slices.Contains(reservedCreateContentKeys, path[0]) {
+		return fmt.Errorf("--randomness-field can't be %s, as it has a meaning in the spec", path[0])
+	}
+	// Like the default field, an existing value is overwritten, but the keys it's nested in must be objects.
+	for i := range path[:len(path)-1] {
+		if existing := gjson.Get(content, exgjson.Path(path[:i+1]...)); existing.Exists() && !existing.IsObject() {
+			return fmt.Errorf("--randomness-field can't be nested inside %s, as it's not an object", strings.Join(path[:i+1], "/"))
+		}
+	}
+	if *randomnessLength < minRandomnessLength || *randomnessLength > maxRandomnessLength {
+		return fmt.Errorf("--randomness-length must be between %d and %d", minRandomnessLength, maxRandomnessLength)
+	} else if window < 0 {
+		return fmt.Errorf("--timestamp-window can't be negative")
+	} else if ts-window < 0 || len(strconv.FormatInt(ts-window, 10)) != len(strconv.FormatInt(ts+window, 10)) {
+		return fmt.Errorf("all timestamps within --timestamp-window must have the same number of digits")
+	} else if *noRandomness && (*randomStart || *randomThreadIDs) {
+		return fmt.Errorf("--random-start and --random-thread-ids can't be used with --no-randomness")
+	} else if *noRandomness && window == 0 {
+		return fmt.Errorf("--no-randomness requires a --timestamp-window to search over")
+	} else if *noRandomness && 2*window+1 < int64(*threadCount) {
+		return fmt.Errorf("--no-randomness requires a --timestamp-window with at least one timestamp per thread")
+	}
+	return nil
+}
+
+// warnKeyspace warns about the chance of finding a match when the only thing that can be varied is the timestamp.
+func warnKeyspace(tpls []*Template, matcher Matcher) {
+	events := float64(tpls[0].TimestampCount() * int64(len(tpls)))
+	chance := -math.Expm1(events * math.Log1p(-matcher.Probability()))
+	_, _ = fmt.Fprintf(os.Stderr, "Without randomness, only %.0f events can be checked, which have a %.3g%% chance of containing a match\n", events, chance*100)
+}
+
+// TimestampCount returns the number of timestamps in the window.
+func (tpl *Template) TimestampCount() int64 {
+	return tpl.MaxTimestamp - tpl.MinTimestamp + 1
+}
+
+// counterEnd returns the value after the last counter value that fits in the randomness, or zero if the counter
+// can use all 64 bits. With less than 8 counter bytes, the counter runs out when it overflows into the bytes
+// that aren't encoded. Without randomness, there's only one event per timestamp.
+func (tpl *Template) counterEnd() uint64 {
+	return uint64(1) << (8 * max(min(tpl.RandomnessLength-2, 8), 0))
+}
+
+// setTimestampPosition moves to the given position in the window, see TimestampPosition.
+func (tpl *Template) setTimestampPosition(position int64) {
+	tpl.TimestampPosition = position
+	tpl.Timestamp = tpl.MinTimestamp + (tpl.OriginalTimestamp-tpl.MinTimestamp+position)%tpl.TimestampCount()
+	digits := strconv.AppendInt(nil, tpl.Timestamp, 10)
+	copy(tpl.PDU[tpl.TimestampOffset:], digits)
+	copy(tpl.PDUWithHash[tpl.TimestampOffsetWithHash:], digits)
+}
+
+// nextTimestamp moves on to the next timestamp in the window, going up from the original one and then wrapping
+// around to the start of the window. Returns false if all the timestamps have been used.
+func (tpl *Template) nextTimestamp() bool {
+	next := tpl.TimestampPosition + tpl.TimestampStep
+	if next >= tpl.TimestampCount() {
+		return false
+	}
+	tpl.setTimestampPosition(next)
+	return true
+}
+
+func (tpl *Template) Clone() *Template {
+	clone := *tpl
+	clone.PDU = bytes.Clone(tpl.PDU)
+	clone.PDUWithHash = bytes.Clone(tpl.PDUWithHash)
+	return &clone
+}
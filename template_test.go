@@ -0,0 +1,91 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCounterEnd(t *testing.T) {
+	tests := map[int]uint64{
+		0:  1,
+		4:  1 << 16,
+		5:  1 << 24,
+		9:  1 << 56,
+		10: 0,
+		16: 0,
+	}
+	for length, expected := range tests {
+		tpl := &Template{RandomnessLength: length}
+		if got := tpl.counterEnd(); got != expected {
+			t.Errorf("counterEnd with %d bytes of randomness = %d, expected %d", length, got, expected)
+		}
+	}
+}
+
+// mineAll runs a worker that accepts every event on a template with 4 bytes of randomness and returns
+// the timestamp and counter of every event it checked, in order.
+func mineAll(t *testing.T, startCounter uint64, timestamps int64) (found [][2]uint64) {
+	oldLength := *randomnessLength
+	*randomnessLength = 4
+	t.Cleanup(func() { *randomnessLength = oldLength })
+	const ts = 1735689600000
+	tpl := NewCreateTemplate("@meow:example.com", ts, json.RawMessage(`{"room_version":"12"}`))
+	tpl.MaxTimestamp = ts + timestamps - 1
+	w := newWorker(1, tpl, CompileMatcher(PrefixMatcher(nil)), func(c *Candidate) bool {
+		randomness, err := base64.RawURLEncoding.DecodeString(gjson.GetBytes(c.PDU, "content.fi\\.mau\\.randomness").Str)
+		if err != nil || len(randomness) != 4 {
+			t.Fatalf("invalid randomness in %s", c.PDU)
+		} else if threadID := binary.BigEndian.Uint16(randomness[:2]); threadID != 1 {
+			t.Fatalf("expected thread ID 1, got %d", threadID)
+		}
+		// The counter is incremented as a native integer, so the encoded bytes are the low bytes in native order.
+		counter := uint64(binary.NativeEndian.Uint16(randomness[2:]))
+		found = append(found, [2]uint64{uint64(gjson.GetBytes(c.PDU, "origin_server_ts").Int() - ts), counter})
+		return true
+	})
+	w.StartCounter = startCounter
+	w.Run(func() {})
+	return
+}
+
+func TestCounterWrapping(t *testing.T) {
+	end := (&Template{RandomnessLength: 4}).counterEnd()
+	found := mineAll(t, end-5, 1)
+	if len(found) != 5 {
+		t.Fatalf("expected the worker to stop after the 5 remaining counter values, got %d events", len(found))
+	}
+	for i, event := range found {
+		if event != [2]uint64{0, end - 5 + uint64(i)} {
+			t.Errorf("event %d has timestamp offset %d and counter %d", i, event[0], event[1])
+		}
+	}
+}
+
+func TestCounterWrappingToNextTimestamp(t *testing.T) {
+	end := (&Template{RandomnessLength: 4}).counterEnd()
+	found := mineAll(t, end-3, 2)
+	if uint64(len(found)) != 3+end {
+		t.Fatalf("expected %d events, got %d", 3+end, len(found))
+	}
+	seen := make(map[[2]uint64]struct{}, len(found))
+	for i, event := range found {
+		if _, dup := seen[event]; dup {
+			t.Fatalf("event %d with timestamp offset %d and counter %d was checked twice", i, event[0], event[1])
+		}
+		seen[event] = struct{}{}
+	}
+	// After the counter wraps, the next timestamp starts from zero.
+	if found[2] != [2]uint64{0, end - 1} || found[3] != [2]uint64{1, 0} || found[len(found)-1] != [2]uint64{1, end - 1} {
+		t.Errorf("unexpected order around the wrap: %v, %v, last %v", found[2], found[3], found[len(found)-1])
+	}
+}
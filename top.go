@@ -0,0 +1,180 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "maunium.net/go/mauflag"
+)
+
+// Running instances whose status hasn't been updated in this long are shown as stale.
+const topStaleAfter = 10 * time.Second
+
+// Status files written with --status-file=auto that haven't been updated in this long belong to instances that
+// have finished or were killed, and are removed by the top command.
+const topForgetAfter = 10 * time.Minute
+
+var topClient = &http.Client{Timeout: 2 * time.Second}
+
+// statusDir is the directory where status files are written with --status-file=auto and discovered by the top command.
+func statusDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "matrix-rig")
+	}
+	return filepath.Join(os.TempDir(), "matrix-rig-"+strconv.Itoa(os.Getuid()))
+}
+
+// resolveStatusFile returns the path to write the status file to, creating the status directory for --status-file=auto.
+func resolveStatusFile(path string) (string, error) {
+	if path != "auto" {
+		return path, nil
+	}
+	dir := statusDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(os.Getpid())+".json"), nil
+}
+
+type topEntry struct {
+	Source string
+	Status *Status
+	Err    error
+}
+
+func readStatus(source string) (*Status, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var resp *http.Response
+		resp, err = topClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err = json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// topSources expands the command-line arguments of the top command into status file paths and URLs.
+// Without arguments, the status directory used by --status-file=auto is searched.
+func topSources() []string {
+	args := flag.Args()[1:]
+	if len(args) == 0 {
+		return autoStatusFiles()
+	}
+	var sources []string
+	for _, arg := range args {
+		if strings.Contains(arg, "://") {
+			sources = append(sources, arg)
+		} else if matches, _ := filepath.Glob(arg); len(matches) > 0 {
+			sources = append(sources, matches...)
+		} else {
+			sources = append(sources, arg)
+		}
+	}
+	return sources
+}
+
+// autoStatusFiles returns the status files in the status directory, removing the ones that are no longer being updated.
+func autoStatusFiles() []string {
+	matches, _ := filepath.Glob(filepath.Join(statusDir(), "*.json"))
+	sources := matches[:0]
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > topForgetAfter {
+			_ = os.Remove(path)
+			continue
+		}
+		sources = append(sources, path)
+	}
+	return sources
+}
+
+func renderTop(entries []topEntry) []byte {
+	var buf bytes.Buffer
+	var running int
+	var totalRate float64
+	now := time.Now()
+	_, _ = fmt.Fprintf(&buf, "%-24s  %7s  %-11s  %-12s  %12s  %10s  %9s  %-12s  %s\n", "Source", "PID", "State", "Prefix", "Hashes", "Hashes/s", "Progress", "ETA", "Best")
+	for _, entry := range entries {
+		source := entry.Source
+		if len(source) > 24 {
+			source = "…" + source[len(source)-23:]
+		}
+		if entry.Err != nil {
+			_, _ = fmt.Fprintf(&buf, "%-24s  %s\n", source, entry.Err)
+			continue
+		}
+		st := entry.Status
+		state := st.State
+		if state == StateRunning && now.Sub(st.UpdatedAt) > topStaleAfter {
+			state = "stale"
+		} else if state == StateRunning {
+			running++
+			totalRate += st.Hashrate
+		}
+		best := "-"
+		if st.Result != nil {
+			best = st.Result.RoomID.String()
+		} else if st.Best != nil {
+			best = fmt.Sprintf("%s (%d)", st.Best.RoomID, st.Best.MatchedLength)
+		}
+		eta := "-"
+		if state == StateRunning && st.ETASeconds > 0 {
+			eta = formatSeconds(st.ETASeconds)
+		}
+		_, _ = fmt.Fprintf(
+			&buf, "%-24s  %7d  %-11s  %-12s  %12d  %10.0f  %8.3g%%  %-12s  %s\n",
+			source, st.PID, state, st.Prefix, st.Hashes, st.Hashrate, st.Progress*100, eta, best,
+		)
+	}
+	_, _ = fmt.Fprintf(&buf, "\n%d instances, %d running, %.0f hashes/s total\n", len(entries), running, totalRate)
+	return buf.Bytes()
+}
+
+func runTop() {
+	for {
+		sources := topSources()
+		entries := make([]topEntry, len(sources))
+		for i, source := range sources {
+			status, err := readStatus(source)
+			entries[i] = topEntry{Source: source, Status: status, Err: err}
+		}
+		output := renderTop(entries)
+		if *topOnce {
+			_, _ = os.Stdout.Write(output)
+			return
+		}
+		// Move the cursor to the top left and clear the screen before redrawing.
+		_, _ = os.Stdout.Write(append([]byte("\033[H\033[2J"), output...))
+		time.Sleep(statusUpdateInterval)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+	"go.mau.fi/util/exgjson"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Keys of the predecessor's create content that are carried forward to the upgraded room,
+// unless the create content given with -c already has them.
+var upgradeCarriedKeys = []string{"type", "m.federate"}
+
+// The state and create content of the predecessor room, if they were fetched with --homeserver.
+var predecessorState mautrix.RoomStateMap
+var predecessorCreateContent gjson.Result
+
+// fetchPredecessor fetches the state of the --predecessor room from the homeserver given with --homeserver.
+// The create content is stored for applyPredecessor, and a warning is logged for any senders who can't send
+// the tombstone event in the old room.
+func fetchPredecessor() error {
+	if *homeserverURL == "" || *predecessor == "" {
+		return nil
+	}
+	cli, err := newMatrixClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	state, err := cli.State(ctx, id.RoomID(*predecessor))
+	if err != nil {
+		return fmt.Errorf("failed to fetch state of %s: %w", *predecessor, err)
+	}
+	createEvt := state[event.StateCreate][""]
+	if createEvt == nil {
+		return fmt.Errorf("the state of %s doesn't have a create event", *predecessor)
+	}
+	// VeryRaw may point into a reused buffer, so the content is re-encoded from the parsed map.
+	content, err := json.Marshal(createEvt.Content.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode create content of %s: %w", *predecessor, err)
+	}
+	predecessorState = state
+	predecessorCreateContent = gjson.ParseBytes(content)
+	_, _ = fmt.Fprintln(os.Stderr, "Fetched create event of", *predecessor, "with content", string(content))
+	for _, sender := range senders() {
+		if !canSendTombstone(state, createEvt, sender) {
+			_, _ = fmt.Fprintln(os.Stderr, "Warning:", sender, "doesn't have permission to send the tombstone in", *predecessor)
+		}
+	}
+	return nil
+}
+
+// canSendTombstone checks whether the given user is allowed to send a tombstone event in a room with the given state.
+// Creators have unlimited power in room versions with derived room IDs, otherwise the power levels decide.
+func canSendTombstone(state mautrix.RoomStateMap, createEvt *event.Event, userID id.UserID) bool {
+	info, _ := getRoomVersion(predecessorCreateContent.Get("room_version").Str)
+	if info.DerivedRoomID && (createEvt.Sender == userID || slices.ContainsFunc(predecessorCreateContent.Get("additional_creators").Array(), func(creator gjson.Result) bool {
+		return creator.Str == userID.String()
+	})) {
+		return true
+	}
+	plEvt := state[event.StatePowerLevels][""]
+	if plEvt == nil {
+		// Without a power level event, only the creator can send state events.
+		return createEvt.Sender == userID
+	}
+	pl := plEvt.Content.AsPowerLevels()
+	return pl.GetUserLevel(userID) >= pl.GetEventLevel(event.StateTombstone)
+}
+
+// applyPredecessor sets the predecessor in the given create content to the room given with --predecessor.
+// The event_id of the predecessor is optional since room version 12, so only the room ID is set.
+// A predecessor that's already in the content must point to the same room. If the create content
+// of the predecessor was fetched, the upgradeCarriedKeys are copied from it too.
+func applyPredecessor(createContent string) (string, error) {
+	if *predecessor == "" {
+		return createContent, nil
+	} else if !strings.HasPrefix(*predecessor, "!") || len(*predecessor) < 2 || strings.ContainsAny(*predecessor, " \t\r\n") {
+		return "", fmt.Errorf("invalid predecessor room ID %s", *predecessor)
+	} else if !gjson.Valid(createContent) {
+		return "", fmt.Errorf("invalid create event content")
+	}
+	for _, key := range upgradeCarriedKeys {
+		path := exgjson.Path(key)
+		if value := predecessorCreateContent.Get(path); value.Exists() && !gjson.Get(createContent, path).Exists() {
+			var err error
+			if createContent, err = sjson.SetRaw(createContent, path, value.Raw); err != nil {
+				return "", fmt.Errorf("failed to copy %s from the predecessor to create content: %w", key, err)
+			}
+		}
+	}
+	if existing := gjson.Get(createContent, "predecessor.room_id"); existing.Exists() {
+		if existing.Str != *predecessor {
+			return "", fmt.Errorf("the create content already has a different predecessor %s", existing.Raw)
+		}
+		return createContent, nil
+	}
+	updated, err := sjson.Set(createContent, "predecessor", map[string]string{"room_id": *predecessor})
+	if err != nil {
+		return "", fmt.Errorf("failed to add predecessor to create content: %w", err)
+	}
+	return updated, nil
+}
+
+// State events that are copied from the predecessor to the upgraded room as initial_state.
+// The power levels are copied separately, as they're sent before the initial state.
+var upgradeCopiedState = []event.Type{
+	event.StateRoomName, event.StateTopic, event.StateRoomAvatar, event.StateJoinRules,
+	event.StateHistoryVisibility, event.StateGuestAccess, event.StateEncryption, event.StateServerACL,
+}
+
+// upgradeRoomRequest returns the /createRoom request for the upgraded room, which copies the state of the predecessor.
+func upgradeRoomRequest(c *Candidate) (map[string]any, error) {
+	req := createRoomRequest(c)
+	var initialState []*initialStateEvent
+	for _, evtType := range upgradeCopiedState {
+		if evt := predecessorState[evtType][""]; evt != nil && len(evt.Content.Raw) > 0 {
+			initialState = append(initialState, &initialStateEvent{Type: evtType, Content: evt.Content.Raw})
+		}
+	}
+	req["initial_state"] = initialState
+	if plEvt := predecessorState[event.StatePowerLevels][""]; plEvt != nil {
+		content, err := json.Marshal(plEvt.Content.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode power levels of %s: %w", *predecessor, err)
+		}
+		// Creators have unlimited power in room versions with derived room IDs and can't be listed in the power levels.
+		newCreateContent := gjson.GetBytes(c.PDU, "content")
+		for _, creator := range append([]gjson.Result{gjson.GetBytes(c.PDU, "sender")}, newCreateContent.Get("additional_creators").Array()...) {
+			content = exerrors.Must(sjson.DeleteBytes(content, "users."+gjson.Escape(creator.Str)))
+		}
+		req["power_level_content_override"] = json.RawMessage(content)
+	}
+	return req, nil
+}
+
+// moveAliases moves the canonical alias and alt aliases of the predecessor to the upgraded room. Only aliases
+// on the homeserver can be moved, and failures are only logged, as the room has already been upgraded.
+func moveAliases(ctx context.Context, cli *mautrix.Client, roomID id.RoomID) {
+	evt := predecessorState[event.StateCanonicalAlias][""]
+	if evt == nil {
+		return
+	}
+	content := evt.Content.AsCanonicalAlias()
+	server := id.UserID(*creator).Homeserver()
+	var moved event.CanonicalAliasEventContent
+	for _, alias := range append([]id.RoomAlias{content.Alias}, content.AltAliases...) {
+		if _, aliasServer, _ := strings.Cut(string(alias), ":"); aliasServer != server {
+			continue
+		} else if _, err := cli.DeleteAlias(ctx, alias); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to remove alias", alias, "from", *predecessor, "-", err)
+			continue
+		} else if _, err = cli.CreateAlias(ctx, alias, roomID); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to add alias", alias, "to", roomID, "-", err)
+			continue
+		}
+		if alias == content.Alias {
+			moved.Alias = alias
+		} else {
+			moved.AltAliases = append(moved.AltAliases, alias)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, "Moved alias", alias, "to", roomID)
+	}
+	if moved.Alias == "" && len(moved.AltAliases) == 0 {
+		return
+	}
+	if _, err := cli.SendStateEvent(ctx, roomID, event.StateCanonicalAlias, "", &moved); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to set canonical alias in", roomID, "-", err)
+	}
+	if _, err := cli.SendStateEvent(ctx, id.RoomID(*predecessor), event.StateCanonicalAlias, "", struct{}{}); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to remove canonical alias from", *predecessor, "-", err)
+	}
+}
+
+// runUpgrade upgrades the --predecessor room to a new room with a matching room ID: it mines the create event,
+// creates the room with the state of the old room, sends the tombstone in the old room and moves the aliases.
+func runUpgrade() {
+	creatorUserID := id.UserID(*creator)
+	if *predecessor == "" || *homeserverURL == "" {
+		fatalf(ExitInvalidInput, "The upgrade command requires --predecessor and --homeserver")
+	} else if _, _, err := creatorUserID.Parse(); err != nil {
+		fatalf(ExitInvalidInput, "Invalid user ID: %s", *creator)
+	} else if len(senders()) > 1 {
+		fatalf(ExitInvalidInput, "Upgrade mode only supports a single user ID")
+	} else if !json.Valid([]byte(*createContent)) {
+		fatalf(ExitInvalidInput, "Invalid create event content")
+	} else if err = checkRoomVersion(*createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if err = checkTemplateFlags(*timestamp, *createContent); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	cli, err := newMatrixClient()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	ctx := context.Background()
+	if whoami, err := cli.Whoami(ctx); err != nil {
+		fatal(ExitBackendFailure, "Failed to check access token:", err)
+	} else if whoami.UserID != creatorUserID {
+		fatalf(ExitInvalidInput, "The access token belongs to %s rather than %s", whoami.UserID, creatorUserID)
+	} else if !canSendTombstone(predecessorState, predecessorState[event.StateCreate][""], creatorUserID) {
+		fatalf(ExitInvalidInput, "%s doesn't have permission to send the tombstone in %s", creatorUserID, *predecessor)
+	}
+	matcher, err := buildMatcher()
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	tpl := NewCreateTemplate(creatorUserID, *timestamp, json.RawMessage(*createContent))
+	if err = checkEventSize(tpl, nil); err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	var deadline <-chan time.Time
+	if *maxSeconds >= 0 {
+		deadline = time.After(time.Duration(*maxSeconds) * time.Second)
+	}
+	found := mineTemplate(tpl, matcher, 1, deadline)
+	if len(found) == 0 {
+		exitWithError(ExitNotFound, "No matching create event found in the time limit")
+	}
+	c := found[0]
+	printResult(c)
+	req, err := upgradeRoomRequest(c)
+	if err != nil {
+		fatal(ExitBackendFailure, err)
+	} else if err = createRoomOnServer(c, req); err != nil {
+		fatal(ExitBackendFailure, "Failed to create room:", err)
+	}
+	_, err = cli.SendStateEvent(ctx, id.RoomID(*predecessor), event.StateTombstone, "", &event.TombstoneEventContent{
+		Body:            "This room has been replaced",
+		ReplacementRoom: c.RoomID(),
+	})
+	if err != nil {
+		fatal(ExitBackendFailure, "Created", c.RoomID(), "but failed to send tombstone:", err)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Sent tombstone in", *predecessor)
+	moveAliases(ctx, cli, c.RoomID())
+}
@@ -0,0 +1,39 @@
+-- v0 -> v2: Latest revision
+CREATE TABLE runs (
+	id          INTEGER PRIMARY KEY,
+	started_at  BIGINT  NOT NULL,
+	finished_at BIGINT,
+	-- One of the Outcome constants, or NULL if the run is still going or crashed.
+	outcome     TEXT,
+	hostname    TEXT    NOT NULL,
+	cpu_model   TEXT    NOT NULL,
+	threads     INTEGER NOT NULL,
+	sender      TEXT    NOT NULL,
+	prefix      TEXT    NOT NULL,
+	timestamp   BIGINT  NOT NULL,
+	content     TEXT    NOT NULL,
+	hashes      BIGINT,
+	hashrate    REAL
+);
+
+CREATE TABLE results (
+	room_id        TEXT    PRIMARY KEY,
+	run_id         INTEGER REFERENCES runs(id) ON DELETE SET NULL,
+	-- The hash of the parameters that affect which create event is found, see cacheSpecHash.
+	spec_hash      TEXT    NOT NULL,
+	sender         TEXT    NOT NULL,
+	prefix         TEXT    NOT NULL,
+	pdu            TEXT    NOT NULL,
+	matched_length INTEGER,
+	found_at       BIGINT  NOT NULL
+);
+CREATE INDEX results_spec_hash_idx ON results (spec_hash);
+
+CREATE TABLE pool (
+	room_id    TEXT   PRIMARY KEY,
+	sender     TEXT   NOT NULL,
+	pdu        TEXT   NOT NULL,
+	added_at   BIGINT NOT NULL,
+	claimed_at BIGINT
+);
+CREATE INDEX pool_available_idx ON pool (sender, room_id) WHERE claimed_at IS NULL;
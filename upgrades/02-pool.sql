@@ -0,0 +1,9 @@
+-- v2: Add pool of pre-mined create events
+CREATE TABLE pool (
+	room_id    TEXT   PRIMARY KEY,
+	sender     TEXT   NOT NULL,
+	pdu        TEXT   NOT NULL,
+	added_at   BIGINT NOT NULL,
+	claimed_at BIGINT
+);
+CREATE INDEX pool_available_idx ON pool (sender, room_id) WHERE claimed_at IS NULL;
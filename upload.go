@@ -0,0 +1,215 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// objectStore uploads files to an S3-compatible bucket. GCS buckets are accessed through
+// the S3-compatible XML API, which requires HMAC keys instead of a service account.
+type objectStore struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Token     string
+}
+
+// newObjectStore parses an s3://bucket or gs://bucket URL and reads credentials from the standard AWS environment variables.
+func newObjectStore(rawURL, endpoint string) (*objectStore, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	} else if parsed.Host == "" {
+		return nil, fmt.Errorf("missing bucket name in %s", rawURL)
+	}
+	store := &objectStore{
+		Bucket:    parsed.Host,
+		Region:    os.Getenv("AWS_REGION"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if store.Region == "" {
+		store.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	switch parsed.Scheme {
+	case "s3":
+		if store.Region == "" {
+			store.Region = "us-east-1"
+		}
+		store.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", store.Region)
+	case "gs":
+		if store.Region == "" {
+			store.Region = "auto"
+		}
+		store.Endpoint = "https://storage.googleapis.com"
+	default:
+		return nil, fmt.Errorf("unsupported upload URL scheme %q, must be s3 or gs", parsed.Scheme)
+	}
+	if endpoint != "" {
+		store.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if store.AccessKey == "" || store.SecretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return store, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapeObjectKey percent-encodes an object key for use in a path as specified for signature version 4,
+// i.e. everything except unreserved characters and slashes is encoded.
+func escapeObjectKey(key string) string {
+	var buf strings.Builder
+	for _, b := range []byte(key) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-._~/", b) >= 0 {
+			buf.WriteByte(b)
+		} else {
+			_, _ = fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the request.
+func (store *objectStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if store.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", store.Token)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + store.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+store.SecretKey), date)
+	key = hmacSHA256(key, store.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// Put uploads the given data to the bucket with the given object key.
+func (store *objectStore) Put(key, contentType string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, store.Endpoint+"/"+store.Bucket+"/"+escapeObjectKey(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	payloadHash := sha256.Sum256(data)
+	store.sign(req, hex.EncodeToString(payloadHash[:]), time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// uploadObjectKey fills in the placeholders in the --upload-key template.
+func uploadObjectKey(name string, result *Candidate) string {
+	roomID, sender := "none", *creator
+	if result != nil {
+		roomID, sender = result.RoomID().String(), result.Sender().String()
+	}
+	return strings.NewReplacer(
+		"{room_id}", roomID,
+		"{prefix}", *prefix,
+		"{sender}", sender,
+		"{timestamp}", strconv.FormatInt(*timestamp, 10),
+		"{name}", name,
+	).Replace(*uploadKey)
+}
+
+// uploadArtifacts uploads the result and the summary file (if summaryPath is set) to the bucket specified with --upload.
+// Failures are only logged, as the result has already been written to stdout at this point.
+func uploadArtifacts(result *Candidate, summaryPath string) {
+	if *uploadURL == "" {
+		return
+	}
+	store, err := newObjectStore(*uploadURL, *uploadEndpoint)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to set up upload:", err)
+		return
+	}
+	upload := func(name, contentType string, data []byte) {
+		key := uploadObjectKey(name, result)
+		if err := store.Put(key, contentType, data); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to upload", key, "-", err)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, "Uploaded", key, "to", *uploadURL)
+		}
+	}
+	if result != nil {
+		data, _ := json.Marshal(NewPorcelainResult(result))
+		upload("result.json", "application/json", data)
+	}
+	if summaryPath != "" {
+		data, err := os.ReadFile(summaryPath)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to read summary for upload:", err)
+			return
+		}
+		contentType := "application/json"
+		if strings.EqualFold(filepath.Ext(summaryPath), ".md") {
+			contentType = "text/markdown"
+		}
+		upload("summary"+filepath.Ext(summaryPath), contentType, data)
+	}
+}
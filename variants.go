@@ -0,0 +1,108 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	flag "maunium.net/go/mauflag"
+)
+
+// wordVariants returns every capitalization of the word, with leet-speak substitutions if leetSpeak is true.
+// Variants containing characters that can't appear in event IDs are left out.
+func wordVariants(word string, leetSpeak bool) ([]string, error) {
+	variants := []string{""}
+	for i := 0; i < len(word); i++ {
+		candidates := string(word[i])
+		if isASCIILetter(word[i]) {
+			candidates += string([]byte{word[i] | 0x20, word[i] & caseFoldMask})
+		}
+		if leetSpeak {
+			candidates += leetSubstitutions[word[i]]
+		}
+		var options []byte
+		for j := 0; j < len(candidates); j++ {
+			if strings.IndexByte(eventIDAlphabet, candidates[j]) != -1 && !slices.Contains(options, candidates[j]) {
+				options = append(options, candidates[j])
+			}
+		}
+		next := make([]string, 0, len(variants)*len(options))
+		for _, variant := range variants {
+			for _, option := range options {
+				next = append(next, variant+string(option))
+			}
+		}
+		if variants = next; len(variants) > maxLeetExpansions {
+			return nil, fmt.Errorf("%s has too many variants, at most %d are supported", word, maxLeetExpansions)
+		}
+	}
+	return variants, nil
+}
+
+func runVariants() {
+	if flag.NArg() != 2 {
+		fatalf(ExitUsage, "Usage: matrix-rig variants [--hashrate=<hashes/s>] <word>")
+	}
+	word := flag.Arg(1)
+	if err := checkAlphabet(word); err != nil {
+		fatal(ExitInvalidInput, err)
+	} else if len(word) > maxPrefixLength {
+		fatalf(ExitInvalidInput, "%s is too long, must be at most %d characters", word, maxPrefixLength)
+	}
+	capitalizations, err := wordVariants(word, false)
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	all, err := wordVariants(word, true)
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	leetVariants := expandLeet(word)
+	slices.Sort(all)
+	expectedHashes := func(variants []string) float64 {
+		m, err := newAlternativesMatcher(variants, nil)
+		if err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+		return 1 / m.Probability()
+	}
+	formatTime := func(expected float64) string {
+		if *hashrate <= 0 {
+			return ""
+		}
+		return formatSeconds(expected / *hashrate)
+	}
+	width := max(len(word), len("Variant"))
+	fmt.Printf("%-*s  %12s", width, "Variant", "Hashes")
+	if *hashrate > 0 {
+		fmt.Printf("  Time at %.0f hashes/s", *hashrate)
+	}
+	fmt.Println()
+	for _, variant := range all {
+		expected := expectedHashes([]string{variant})
+		fmt.Printf("%-*s  %12.4g  %s\n", width, variant, expected, formatTime(expected))
+	}
+	// Every variant has the same length and thus the same difficulty, so the real choice is how many to accept.
+	fmt.Println("\nAll variants are equally hard, but accepting several at once (e.g. as a comma-separated -p list) is faster:")
+	for _, group := range []struct {
+		label    string
+		variants []string
+	}{
+		{"capitalizations (--ignore-case)", capitalizations},
+		{"leet-speak variants (--leet)", leetVariants},
+		{"variants", all},
+	} {
+		expected := expectedHashes(group.variants)
+		fmt.Printf("Any of the %d %s: expected %.4g hashes", len(group.variants), group.label, expected)
+		if *hashrate > 0 {
+			fmt.Printf(", %s", formatTime(expected))
+		}
+		fmt.Println()
+	}
+}
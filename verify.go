@@ -0,0 +1,112 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exerrors"
+
+	flag "maunium.net/go/mauflag"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// VerifyResult is written to stdout in --porcelain mode by the verify command.
+type VerifyResult struct {
+	RoomID      id.RoomID `json:"room_id"`
+	ContentHash string    `json:"content_hash"`
+	// Whether the content hash in the event matches the recomputed one. False if the event doesn't have one.
+	ContentHashValid bool `json:"content_hash_valid"`
+	// Whether the room ID matches -p, if it was given.
+	Matches *bool `json:"matches,omitempty"`
+}
+
+// readVerifyInput reads the create event for the verify command from the given file, or stdin if the path is - or empty.
+// The input may also be a --porcelain result, in which case the room ID it claims is returned too.
+func readVerifyInput(path string) (pdu []byte, claimedRoomID id.RoomID, err error) {
+	var data []byte
+	if path == "" || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read create event: %w", err)
+	}
+	return parseCreateEventInput(data)
+}
+
+// parseCreateEventInput validates a create event or a --porcelain result and returns the create event in canonical form.
+func parseCreateEventInput(data []byte) (pdu []byte, claimedRoomID id.RoomID, err error) {
+	if !gjson.ValidBytes(data) || !gjson.ParseBytes(data).IsObject() {
+		return nil, "", fmt.Errorf("the create event must be a JSON object")
+	}
+	if result := gjson.ParseBytes(data); result.Get("pdu").IsObject() && !result.Get("type").Exists() {
+		data = []byte(result.Get("pdu").Raw)
+		claimedRoomID = id.RoomID(result.Get("room_id").Str)
+	}
+	pdu, err = normalizeJSON(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid create event: %w", err)
+	} else if eventType := gjson.GetBytes(pdu, "type").Str; eventType != "m.room.create" {
+		return nil, "", fmt.Errorf("expected an m.room.create event, got %q", eventType)
+	} else if err = checkRoomVersion(gjson.GetBytes(pdu, "content").Raw); err != nil {
+		return nil, "", err
+	}
+	return pdu, claimedRoomID, nil
+}
+
+// runVerify recomputes the content hash and reference hash of an existing create event
+// and prints the room ID derived from it, optionally checking it against -p.
+func runVerify() {
+	pdu, claimedRoomID, err := readVerifyInput(flag.Arg(1))
+	if err != nil {
+		fatal(ExitInvalidInput, err)
+	}
+	var matcher *CompiledMatcher
+	if firstTargetPrefix() != "" {
+		if matcher, err = buildMatcher(); err != nil {
+			fatal(ExitInvalidInput, err)
+		}
+	}
+	existingHash := gjson.GetBytes(pdu, "hashes.sha256")
+	breakdown := NewHashBreakdown(pdu)
+	hashValid := existingHash.Str == breakdown.ContentHash
+	if !existingHash.Exists() {
+		// The reference hash covers the content hash, so the room ID can only be derived with one.
+		_, _ = fmt.Fprintln(os.Stderr, "Warning: the event doesn't have a content hash, using the recomputed one")
+		pdu = exerrors.Must(sjson.SetBytes(pdu, "hashes", map[string]string{"sha256": breakdown.ContentHash}))
+		breakdown = NewHashBreakdown(pdu)
+	} else if !hashValid {
+		_, _ = fmt.Fprintf(os.Stderr, "Content hash mismatch: the event has %s, but the content hash is %s\n", existingHash.Str, breakdown.ContentHash)
+	}
+	roomID := id.RoomID(*roomIDSigil + breakdown.ReferenceHash)
+	result := &VerifyResult{RoomID: roomID, ContentHash: breakdown.ContentHash, ContentHashValid: hashValid}
+	if matcher != nil {
+		matches := matcher.Match([]byte(breakdown.ReferenceHash))
+		result.Matches = &matches
+	}
+	if *porcelain {
+		_ = json.NewEncoder(os.Stdout).Encode(result)
+	} else {
+		fmt.Println(roomID)
+	}
+	if claimedRoomID != "" && claimedRoomID != roomID {
+		fatalf(ExitInvalidInput, "The result claims the room ID %s, but the create event derives %s", claimedRoomID, roomID)
+	} else if existingHash.Exists() && !hashValid {
+		exitWithError(ExitInvalidInput, "The content hash in the event is invalid")
+	} else if result.Matches != nil && !*result.Matches {
+		exitWithError(ExitNotFound, fmt.Sprintf("%s doesn't match %s", roomID, *prefix))
+	}
+	os.Exit(ExitFound)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// splitPrefixWeight splits a -p value in the form prefix=weight. = can't appear in room IDs, so it's unambiguous.
+func splitPrefixWeight(arg string) (prefix, weight string) {
+	prefix, weight, _ = strings.Cut(arg, "=")
+	return
+}
+
+type weightedPrefix struct {
+	prefix  string
+	weight  float64
+	matcher Matcher
+}
+
+// weightedSearch buffers candidates for weighted prefixes, so that the search can continue
+// until a candidate for the highest-weighted prefix is found.
+type weightedSearch struct {
+	prefixes  []weightedPrefix
+	maxWeight float64
+
+	lock       sync.Mutex
+	best       *Candidate
+	bestWeight float64
+}
+
+// newWeightedSearch parses the weights given with -p prefix=weight, or returns nil if none of the prefixes have one.
+// Prefixes without a weight have a weight of 1.
+func newWeightedSearch() (*weightedSearch, error) {
+	ws := &weightedSearch{}
+	hasWeights := false
+	for _, arg := range rawTargetPrefixes() {
+		prefix, weightStr := splitPrefixWeight(arg)
+		wp := weightedPrefix{prefix: prefix, weight: 1}
+		if weightStr != "" {
+			var err error
+			if wp.weight, err = strconv.ParseFloat(weightStr, 64); err != nil || wp.weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for prefix %s, must be a positive number", weightStr, prefix)
+			}
+			hasWeights = true
+		}
+		variants := []string{prefix}
+		if *leet {
+			variants = expandLeet(prefix)
+		}
+		var err error
+		if wp.matcher, err = newAlternativesMatcher(variants, nil); err != nil {
+			return nil, err
+		}
+		ws.prefixes = append(ws.prefixes, wp)
+		ws.maxWeight = max(ws.maxWeight, wp.weight)
+	}
+	if !hasWeights {
+		return nil, nil
+	}
+	return ws, nil
+}
+
+// weightOf returns the highest weight of the prefixes that the candidate matches.
+func (ws *weightedSearch) weightOf(c *Candidate) (weight float64) {
+	for _, wp := range ws.prefixes {
+		if wp.weight > weight && wp.matcher.Match([]byte(c.EventID)) {
+			weight = wp.weight
+		}
+	}
+	return
+}
+
+// Offer returns true if the candidate matches the highest-weighted prefix and the search should end.
+// Otherwise, the candidate is kept if it's better than the previously seen ones.
+func (ws *weightedSearch) Offer(c *Candidate) bool {
+	weight := ws.weightOf(c)
+	if weight >= ws.maxWeight {
+		return true
+	}
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if weight > ws.bestWeight {
+		ws.best, ws.bestWeight = c, weight
+		_, _ = fmt.Fprintf(os.Stderr, "Found %s with weight %g, continuing to look for weight %g\n", c.RoomID(), weight, ws.maxWeight)
+	}
+	return false
+}
+
+// Best returns the highest-weighted candidate buffered so far.
+func (ws *weightedSearch) Best() *Candidate {
+	if ws == nil {
+		return nil
+	}
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	return ws.best
+}